@@ -3,8 +3,7 @@ module github.com/krshock/mob84hub
 go 1.22.5
 
 require (
+	github.com/gorilla/websocket v1.5.0
 	github.com/olahol/melody v1.2.1
 	golang.org/x/exp v0.0.0-20241108190413-2d47ceb2692f
 )
-
-require github.com/gorilla/websocket v1.5.0 // indirect
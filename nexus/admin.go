@@ -0,0 +1,258 @@
+package nexus
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	melody "github.com/olahol/melody"
+)
+
+// Page size limits of the admin API lists
+const (
+	DefaultAdminPageSize = 50
+	MaxAdminPageSize     = 500
+)
+
+// AdminPage is the response of the admin API list endpoints, pages start at 0
+type AdminPage[T any] struct {
+	Page     int `json:"page"`
+	PageSize int `json:"page_size"`
+	Total    int `json:"total"`
+	Items    []T `json:"items"`
+}
+
+// Returns the page of items requested with the page and page_size query parameters
+func paginate[T any](r *http.Request, items []T) AdminPage[T] {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	page = max(page, 0)
+	page_size, _ := strconv.Atoi(r.URL.Query().Get("page_size"))
+	if page_size <= 0 {
+		page_size = DefaultAdminPageSize
+	}
+	page_size = min(page_size, MaxAdminPageSize)
+	start := min(page*page_size, len(items))
+	end := min(start+page_size, len(items))
+	return AdminPage[T]{Page: page, PageSize: page_size, Total: len(items), Items: items[start:end]}
+}
+
+// Keeps the items of an AppName if the app_name query parameter is set
+func filterApp[T any](r *http.Request, items []T, app_name func(T) string) []T {
+	if !r.URL.Query().Has("app_name") {
+		return items
+	}
+	app := r.URL.Query().Get("app_name")
+	filtered := make([]T, 0)
+	for _, item := range items {
+		if app_name(item) == app {
+			filtered = append(filtered, item)
+		}
+	}
+	return filtered
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// Wraps an admin API handler, requests must carry the hub's admin token as a bearer token.
+// The admin API is disabled if the hub has no admin token.
+func (hub *Hub) adminOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if hub.AdminToken == "" {
+			http.NotFound(w, r)
+			return
+		}
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(hub.AdminToken)) != 1 {
+			writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid admin token"})
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// GET /admin/rooms, lists rooms filtered by app_name
+func (hub *Hub) HandleAdminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms := filterApp(r, hub.collectRooms(), func(room RoomInfo) string { return room.AppName })
+	writeJSON(w, http.StatusOK, paginate(r, rooms))
+}
+
+// GET /admin/rooms/{name}, returns a room with its peer list
+func (hub *Hub) HandleAdminRoom(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	for _, room := range hub.collectRooms() {
+		if room.Name != name {
+			continue
+		}
+		room.Peers = make([]ClientInfo, 0)
+		for _, cli := range hub.collectClients() {
+			if cli.RoomName == name {
+				room.Peers = append(room.Peers, cli)
+			}
+		}
+		writeJSON(w, http.StatusOK, room)
+		return
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"error": "room not found"})
+}
+
+// GET /admin/sessions, lists client sessions filtered by app_name
+func (hub *Hub) HandleAdminSessions(w http.ResponseWriter, r *http.Request) {
+	clients := filterApp(r, hub.collectClients(), func(cli ClientInfo) string { return cli.AppName })
+	writeJSON(w, http.StatusOK, paginate(r, clients))
+}
+
+// Processes an admin command in the hub goroutine, room commands are forwarded to the
+// room goroutine
+func (hub *Hub) handleAdminCmd(cmd HubChanCmd) {
+	if cmd.Id == HUB_CHAN_CMD_KICK_SESSION {
+		value, _ := hub.SessionIds.Load(cmd.Name)
+		s, _ := value.(*SessionInfo)
+		if s == nil {
+			return
+		}
+		hub.logger(LOG_ADMIN).Info("admin kick", "unique_id", s.UniqueId, "remote_addr", s.RemoteAddr())
+		if room := s.getRoom(); room != nil {
+			room.offerCmd(RoomChanCmd{Id: ROOM_CHAN_CMD_KICK, Session: s})
+		} else {
+			s.SendError(ERR_KICKED, "")
+			s.disconnect()
+		}
+		return
+	}
+
+	value, _ := hub.RoomMap.Load(cmd.Name)
+	room, _ := value.(*Room)
+	if room == nil {
+		return
+	}
+	if cmd.Id == HUB_CHAN_CMD_CLOSE_ROOM {
+		hub.logger(LOG_ADMIN).Info("admin close room", "room", room.Name, "app", room.AppName)
+		room.offerCmd(RoomChanCmd{Id: ROOM_CHAN_CMD_ROOM_CLOSE})
+	} else if cmd.Id == HUB_CHAN_CMD_SET_ALLOW_JOIN {
+		room.offerCmd(RoomChanCmd{Id: ROOM_CHAN_CMD_SET_ALLOW_JOIN, IntVal: cmd.IntVal})
+	} else if cmd.Id == HUB_CHAN_CMD_ROOM_MESSAGE {
+		room.offerCmd(RoomChanCmd{Id: ROOM_CHAN_CMD_SEND_PACKET, PacketTarget: 255, Msg: buildMsgPacket(MSG_SYSTEM, 0, cmd.Text)})
+	}
+}
+
+// Checks that a room exists before queueing an admin command for it
+func (hub *Hub) queueRoomAdminCmd(w http.ResponseWriter, cmd HubChanCmd) {
+	if value, _ := hub.RoomMap.Load(cmd.Name); value == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "room not found"})
+		return
+	}
+	if !hub.sendCmd(cmd) {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "hub stopped"})
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "queued"})
+}
+
+// POST /admin/sessions/{id}/kick, disconnects a session by UniqueId
+func (hub *Hub) HandleAdminKick(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if value, _ := hub.SessionIds.Load(id); value == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "session not found"})
+		return
+	}
+	if !hub.sendCmd(HubChanCmd{Id: HUB_CHAN_CMD_KICK_SESSION, Name: id}) {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "hub stopped"})
+		return
+	}
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "queued"})
+}
+
+// POST /admin/rooms/{name}/close, closes a room and disconnects its peers
+func (hub *Hub) HandleAdminCloseRoom(w http.ResponseWriter, r *http.Request) {
+	hub.queueRoomAdminCmd(w, HubChanCmd{Id: HUB_CHAN_CMD_CLOSE_ROOM, Name: r.PathValue("name")})
+}
+
+// POST /admin/rooms/{name}/allow_join?allow=true|false, sets the room's AllowJoin
+func (hub *Hub) HandleAdminAllowJoin(w http.ResponseWriter, r *http.Request) {
+	allow, err := strconv.ParseBool(r.URL.Query().Get("allow"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "allow must be true or false"})
+		return
+	}
+	cmd := HubChanCmd{Id: HUB_CHAN_CMD_SET_ALLOW_JOIN, Name: r.PathValue("name")}
+	if allow {
+		cmd.IntVal = 1
+	}
+	hub.queueRoomAdminCmd(w, cmd)
+}
+
+// POST /admin/rooms/{name}/message, sends the request body to every peer of the room as a
+// MSG_SYSTEM packet
+func (hub *Hub) HandleAdminRoomMessage(w http.ResponseWriter, r *http.Request) {
+	text, err := io.ReadAll(io.LimitReader(r.Body, 1024))
+	if err != nil || len(text) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "message text required"})
+		return
+	}
+	hub.queueRoomAdminCmd(w, HubChanCmd{Id: HUB_CHAN_CMD_ROOM_MESSAGE, Name: r.PathValue("name"), Text: string(text)})
+}
+
+// Body of POST /admin/bans, Duration is empty for permanent bans
+type BanRequest struct {
+	IP             string   `json:"ip"`
+	UserId         string   `json:"user_id"`
+	UniqueIdPrefix string   `json:"unique_id_prefix"`
+	Reason         string   `json:"reason"`
+	Duration       Duration `json:"duration"`
+}
+
+// GET /admin/bans, lists the active bans
+func (hub *Hub) HandleAdminBans(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, paginate(r, hub.Bans.List()))
+}
+
+// POST /admin/bans, stores a ban and kicks the connected sessions matching it
+func (hub *Hub) HandleAdminAddBan(w http.ResponseWriter, r *http.Request) {
+	req := BanRequest{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 4096)).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	ban := &Ban{IP: req.IP, UserId: req.UserId, UniqueIdPrefix: req.UniqueIdPrefix, Reason: req.Reason}
+	if req.Duration > 0 {
+		ban.ExpiresAt = time.Now().Add(time.Duration(req.Duration))
+	}
+	if err := hub.Bans.Add(ban); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	hub.SessionMap.Range(func(k any, v any) bool {
+		s := v.(*SessionInfo)
+		if ban.matches(net.ParseIP(remoteIP(k.(*melody.Session))), s.UserId, s.UniqueId) {
+			hub.sendCmd(HubChanCmd{Id: HUB_CHAN_CMD_KICK_SESSION, Name: s.UniqueId})
+		}
+		return true
+	})
+	writeJSON(w, http.StatusCreated, ban)
+}
+
+// DELETE /admin/bans/{id}, removes a ban
+func (hub *Hub) HandleAdminRemoveBan(w http.ResponseWriter, r *http.Request) {
+	removed, err := hub.Bans.Remove(r.PathValue("id"))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+	} else if !removed {
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "ban not found"})
+	} else {
+		writeJSON(w, http.StatusOK, map[string]string{"status": "removed"})
+	}
+}
+
+// GET /admin/stats, returns memory and hub stats
+func (hub *Hub) HandleAdminStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, hub.collectSysInfo())
+}
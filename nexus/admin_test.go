@@ -0,0 +1,136 @@
+package nexus
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// Sends an admin API request to srv with the Authorization header auth, empty to omit it
+func adminRequest(t *testing.T, srv *httptest.Server, method string, path string, auth string) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestAdminAuth(t *testing.T) {
+	tests := []struct {
+		name   string
+		token  string
+		auth   string
+		status int
+	}{
+		{"admin disabled", "", "Bearer ", http.StatusNotFound},
+		{"admin disabled with token", "", "Bearer secret", http.StatusNotFound},
+		{"missing header", "secret", "", http.StatusUnauthorized},
+		{"basic auth", "secret", "Basic c2VjcmV0", http.StatusUnauthorized},
+		{"lowercase scheme", "secret", "bearer secret", http.StatusUnauthorized},
+		{"empty token", "secret", "Bearer ", http.StatusUnauthorized},
+		{"wrong token", "secret", "Bearer wrong", http.StatusUnauthorized},
+		{"token prefix", "secret", "Bearer secre", http.StatusUnauthorized},
+		{"token suffix", "secret", "Bearer secret2", http.StatusUnauthorized},
+		{"valid token", "secret", "Bearer secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, srv := newTestHub(t, WithAdminToken(tt.token))
+			for _, path := range []string{"/admin/rooms", "/admin/sessions", "/admin/stats"} {
+				if resp := adminRequest(t, srv, "GET", path, tt.auth); resp.StatusCode != tt.status {
+					t.Fatalf("GET %s: status %d, want %d", path, resp.StatusCode, tt.status)
+				}
+			}
+			// Commands are checked before they reach the hub
+			if tt.status != http.StatusOK {
+				if resp := adminRequest(t, srv, "POST", "/admin/sessions/x/kick", tt.auth); resp.StatusCode != tt.status {
+					t.Fatalf("POST kick: status %d, want %d", resp.StatusCode, tt.status)
+				}
+			}
+		})
+	}
+}
+
+func TestPaginate(t *testing.T) {
+	items := []int{0, 1, 2, 3, 4, 5, 6}
+	tests := []struct {
+		query     string
+		page      int
+		page_size int
+		items     []int
+	}{
+		{"", 0, DefaultAdminPageSize, items},
+		{"?page_size=3", 0, 3, []int{0, 1, 2}},
+		{"?page=1&page_size=3", 1, 3, []int{3, 4, 5}},
+		{"?page=2&page_size=3", 2, 3, []int{6}},
+		{"?page=3&page_size=3", 3, 3, []int{}},
+		{"?page=1000000&page_size=3", 1000000, 3, []int{}},
+		{"?page=-1&page_size=3", 0, 3, []int{0, 1, 2}},
+		{"?page=x&page_size=3", 0, 3, []int{0, 1, 2}},
+		{"?page_size=0", 0, DefaultAdminPageSize, items},
+		{"?page_size=-5", 0, DefaultAdminPageSize, items},
+		{"?page_size=x", 0, DefaultAdminPageSize, items},
+		{"?page_size=100000", 0, MaxAdminPageSize, items},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			page := paginate(httptest.NewRequest("GET", "/admin/rooms"+tt.query, nil), items)
+			if page.Page != tt.page || page.PageSize != tt.page_size || page.Total != len(items) {
+				t.Fatalf("page %d size %d total %d, want page %d size %d total %d", page.Page, page.PageSize, page.Total, tt.page, tt.page_size, len(items))
+			}
+			if !slices.Equal(page.Items, tt.items) {
+				t.Fatalf("items %v, want %v", page.Items, tt.items)
+			}
+		})
+	}
+}
+
+func TestAdminSessionsPage(t *testing.T) {
+	hub, srv := newTestHub(t, WithAdminToken("secret"))
+	for _, app := range []string{"game", "game", "other"} {
+		dialTest(t, srv, "").hello(app)
+	}
+	waitFor(t, "3 sessions", func() bool { return len(hub.collectClients()) == 3 })
+
+	tests := []struct {
+		query string
+		total int
+		items int
+	}{
+		{"?page_size=2", 3, 2},
+		{"?page=1&page_size=2", 3, 1},
+		{"?page=2&page_size=2", 3, 0},
+		{"?app_name=game", 2, 2},
+		{"?app_name=game&page=1&page_size=1", 2, 1},
+		{"?app_name=none", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			resp := adminRequest(t, srv, "GET", "/admin/sessions"+tt.query, "Bearer secret")
+			if resp.StatusCode != http.StatusOK {
+				t.Fatalf("status %d", resp.StatusCode)
+			}
+			page := AdminPage[ClientInfo]{}
+			if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+				t.Fatal(err)
+			}
+			if page.Total != tt.total || len(page.Items) != tt.items {
+				t.Fatalf("total %d with %d items, want total %d with %d items", page.Total, len(page.Items), tt.total, tt.items)
+			}
+			if page.Items == nil {
+				t.Fatal("items is null, want a JSON list")
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package nexus
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// AuthPolicy tells what unauthenticated clients can do with the rooms of an AppName
+type AuthPolicy int
+
+const (
+	AUTH_POLICY_OPTIONAL  AuthPolicy = iota // Tokens are verified if present
+	AUTH_POLICY_JOIN_ONLY                   // Only authenticated clients can create rooms
+	AUTH_POLICY_REQUIRED                    // Unauthenticated clients are rejected
+)
+
+// Claims read from an auth token. Sub is the verified user id and Name the display name
+// used instead of the player_name of room requests.
+type AuthClaims struct {
+	Sub       string `json:"sub"`
+	Name      string `json:"name"`
+	ExpiresAt int64  `json:"exp"`
+	NotBefore int64  `json:"nbf"`
+}
+
+var (
+	ErrTokenMalformed = errors.New("malformed token")
+	ErrTokenAlgorithm = errors.New("unsupported token algorithm")
+	ErrTokenSignature = errors.New("invalid token signature")
+	ErrTokenExpired   = errors.New("token expired or not yet valid")
+)
+
+// Verifies a HS256 JWT signed with key and returns its claims
+func VerifyAuthToken(token string, key []byte) (*AuthClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenMalformed
+	}
+	header_json, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	header := struct {
+		Alg string `json:"alg"`
+	}{}
+	if json.Unmarshal(header_json, &header) != nil {
+		return nil, ErrTokenMalformed
+	}
+	if header.Alg != "HS256" {
+		return nil, ErrTokenAlgorithm
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(sig, mac.Sum(nil)) {
+		return nil, ErrTokenSignature
+	}
+
+	claims_json, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrTokenMalformed
+	}
+	claims := &AuthClaims{}
+	if json.Unmarshal(claims_json, claims) != nil || claims.Sub == "" {
+		return nil, ErrTokenMalformed
+	}
+	now := time.Now().Unix()
+	if (claims.ExpiresAt != 0 && now >= claims.ExpiresAt) || (claims.NotBefore != 0 && now < claims.NotBefore) {
+		return nil, ErrTokenExpired
+	}
+	return claims, nil
+}
+
+// Creates a HS256 JWT signed with key, for backends that issue tokens to their players
+func SignAuthToken(claims *AuthClaims, key []byte) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+	claims_json, _ := json.Marshal(claims)
+	payload := base64.RawURLEncoding.EncodeToString(claims_json)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(header + "." + payload))
+	return header + "." + payload + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Returns the auth policy of an AppName
+func (hub *Hub) authPolicy(app_name string) AuthPolicy {
+	return hub.appPolicy(app_name).Auth
+}
+
+// Verifies the token sent by a client in the handshake. Returns false if the session must
+// be rejected.
+func (hub *Hub) authenticate(s *SessionInfo, token string) bool {
+	if conn := s.conn(); token == "" && conn != nil {
+		token, _ = conn.Keys["token"].(string)
+	}
+	if token != "" {
+		if len(hub.AuthKey) == 0 {
+			s.SendError(ERR_INVALID_TOKEN, "")
+			return false
+		}
+		claims, err := VerifyAuthToken(token, hub.AuthKey)
+		if err != nil {
+			s.SendError(ERR_INVALID_TOKEN, err.Error())
+			return false
+		}
+		s.UserId = claims.Sub
+		s.VerifiedName = claims.Name
+		s.Verified = true
+	}
+	if !s.Verified && hub.authPolicy(s.AppName) == AUTH_POLICY_REQUIRED {
+		s.SendError(ERR_AUTH_REQUIRED, "")
+		return false
+	}
+	return true
+}
+
+// Checks the auth policy of an AppName before a session creates or joins one of its rooms
+func (hub *Hub) authorizeRoom(s *SessionInfo, app_name string, create bool) bool {
+	if !hub.checkAppOrigin(s, app_name) {
+		return false
+	}
+	if s.Verified {
+		return true
+	}
+	policy := hub.authPolicy(app_name)
+	if policy == AUTH_POLICY_REQUIRED || (create && policy == AUTH_POLICY_JOIN_ONLY) {
+		s.SendError(ERR_AUTH_REQUIRED, app_name)
+		return false
+	}
+	return true
+}
+
+// Name shown to other peers, verified sessions use the name of their token
+func (s *SessionInfo) displayName(player_name string) string {
+	if s.Verified && s.VerifiedName != "" {
+		return s.VerifiedName
+	}
+	return player_name
+}
@@ -0,0 +1,166 @@
+package nexus
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testAuthKey = []byte("test-key")
+
+// Builds a token from a raw header and claims. A nil key leaves the signature empty.
+func rawToken(header string, claims string, key []byte) string {
+	enc := base64.RawURLEncoding
+	token := enc.EncodeToString([]byte(header)) + "." + enc.EncodeToString([]byte(claims))
+	if key == nil {
+		return token + "."
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(token))
+	return token + "." + enc.EncodeToString(mac.Sum(nil))
+}
+
+// Replaces part idx of a token with the encoding of raw
+func replacePart(token string, idx int, raw string) string {
+	parts := strings.Split(token, ".")
+	parts[idx] = base64.RawURLEncoding.EncodeToString([]byte(raw))
+	return strings.Join(parts, ".")
+}
+
+func TestVerifyAuthToken(t *testing.T) {
+	now := time.Now().Unix()
+	hs256 := `{"alg":"HS256","typ":"JWT"}`
+	good_token := rawToken(hs256, `{"sub":"u1"}`, testAuthKey)
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{"good", SignAuthToken(&AuthClaims{Sub: "u1", Name: "Ana", ExpiresAt: now + 60, NotBefore: now - 60}, testAuthKey), nil},
+		{"no expiry", good_token, nil},
+		{"bad signature", SignAuthToken(&AuthClaims{Sub: "u1"}, []byte("other-key")), ErrTokenSignature},
+		{"tampered claims", replacePart(rawToken(hs256, `{"sub":"u2"}`, testAuthKey), 1, `{"sub":"u1"}`), ErrTokenSignature},
+		{"alg none", rawToken(`{"alg":"none","typ":"JWT"}`, `{"sub":"u1"}`, nil), ErrTokenAlgorithm},
+		{"alg none signed", rawToken(`{"alg":"none"}`, `{"sub":"u1"}`, testAuthKey), ErrTokenAlgorithm},
+		{"alg HS512", rawToken(`{"alg":"HS512"}`, `{"sub":"u1"}`, testAuthKey), ErrTokenAlgorithm},
+		{"alg RS256", rawToken(`{"alg":"RS256"}`, `{"sub":"u1"}`, testAuthKey), ErrTokenAlgorithm},
+		{"no alg", rawToken(`{"typ":"JWT"}`, `{"sub":"u1"}`, testAuthKey), ErrTokenAlgorithm},
+		{"expired", rawToken(hs256, `{"sub":"u1","exp":`+strconv.FormatInt(now-1, 10)+`}`, testAuthKey), ErrTokenExpired},
+		{"future nbf", rawToken(hs256, `{"sub":"u1","nbf":`+strconv.FormatInt(now+60, 10)+`}`, testAuthKey), ErrTokenExpired},
+		{"missing sub", rawToken(hs256, `{"name":"Ana"}`, testAuthKey), ErrTokenMalformed},
+		{"empty sub", rawToken(hs256, `{"sub":""}`, testAuthKey), ErrTokenMalformed},
+		{"claims not json", rawToken(hs256, `sub`, testAuthKey), ErrTokenMalformed},
+		{"two parts", "a.b", ErrTokenMalformed},
+		{"empty", "", ErrTokenMalformed},
+		{"header not base64", "!!" + good_token[strings.Index(good_token, "."):], ErrTokenMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := VerifyAuthToken(tt.token, testAuthKey)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			if err == nil && claims.Sub != "u1" {
+				t.Fatalf("sub %q, want u1", claims.Sub)
+			}
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	good := SignAuthToken(&AuthClaims{Sub: "u1", Name: "Ana"}, testAuthKey)
+	tests := []struct {
+		name     string
+		policy   AuthPolicy
+		token    string
+		ok       bool
+		verified bool
+	}{
+		{"optional anonymous", AUTH_POLICY_OPTIONAL, "", true, false},
+		{"optional token", AUTH_POLICY_OPTIONAL, good, true, true},
+		{"optional bad token", AUTH_POLICY_OPTIONAL, good + "x", false, false},
+		{"join_only anonymous", AUTH_POLICY_JOIN_ONLY, "", true, false},
+		{"required anonymous", AUTH_POLICY_REQUIRED, "", false, false},
+		{"required token", AUTH_POLICY_REQUIRED, good, true, true},
+		{"required bad token", AUTH_POLICY_REQUIRED, SignAuthToken(&AuthClaims{Sub: "u1"}, []byte("other-key")), false, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub := NewHub(WithAuthKey(testAuthKey), WithAuthPolicy("game", tt.policy))
+			s := &SessionInfo{Hub: hub, AppName: "game"}
+			if ok := hub.authenticate(s, tt.token); ok != tt.ok {
+				t.Fatalf("authenticate %v, want %v", ok, tt.ok)
+			}
+			if s.Verified != tt.verified {
+				t.Fatalf("verified %v, want %v", s.Verified, tt.verified)
+			}
+			if tt.verified && (s.UserId != "u1" || s.displayName("player") != "Ana") {
+				t.Fatalf("user %q name %q", s.UserId, s.displayName("player"))
+			}
+		})
+	}
+
+	// Tokens can't be verified by a hub without a key
+	hub := NewHub()
+	if hub.authenticate(&SessionInfo{Hub: hub}, good) {
+		t.Fatal("token accepted without an auth key")
+	}
+}
+
+func TestAuthorizeRoom(t *testing.T) {
+	tests := []struct {
+		policy   AuthPolicy
+		verified bool
+		create   bool
+		join     bool
+	}{
+		{AUTH_POLICY_OPTIONAL, false, true, true},
+		{AUTH_POLICY_OPTIONAL, true, true, true},
+		{AUTH_POLICY_JOIN_ONLY, false, false, true},
+		{AUTH_POLICY_JOIN_ONLY, true, true, true},
+		{AUTH_POLICY_REQUIRED, false, false, false},
+		{AUTH_POLICY_REQUIRED, true, true, true},
+	}
+	for _, tt := range tests {
+		hub := NewHub(WithAuthPolicy("game", tt.policy))
+		s := &SessionInfo{Hub: hub, Verified: tt.verified}
+		if ok := hub.authorizeRoom(s, "game", true); ok != tt.create {
+			t.Errorf("policy %d verified %v: create %v, want %v", tt.policy, tt.verified, ok, tt.create)
+		}
+		if ok := hub.authorizeRoom(s, "game", false); ok != tt.join {
+			t.Errorf("policy %d verified %v: join %v, want %v", tt.policy, tt.verified, ok, tt.join)
+		}
+		// Other apps use the default policy
+		if !hub.authorizeRoom(s, "other", true) {
+			t.Errorf("policy %d leaked to other apps", tt.policy)
+		}
+	}
+}
+
+// Tokens can also be sent in the token query parameter of /ws
+func TestAuthQueryToken(t *testing.T) {
+	_, srv := newTestHub(t, WithAuthKey(testAuthKey), WithAuthPolicy("game", AUTH_POLICY_JOIN_ONLY))
+	anon := dialTest(t, srv, "")
+	anon.hello("game")
+	anon.sendJSON([]byte{PACKET_HUB, HUB_CMD_SC_CREATE_ROOM}, RoomRequest{AppName: "game", RoomSecret: "pwd"})
+	anon.expectError(ERR_AUTH_REQUIRED)
+
+	host := dialTest(t, srv, "?token="+SignAuthToken(&AuthClaims{Sub: "u1", Name: "Ana"}, testAuthKey))
+	host.hello("game")
+	room := host.createRoom(RoomRequest{AppName: "game", RoomSecret: "pwd", PlayerName: "spoofed", AllowJoin: true})
+	anon.sendJSON([]byte{PACKET_HUB, HUB_CMD_SC_JOIN_ROOM}, RoomRequest{RoomId: room, AppName: "game", RoomSecret: "pwd"})
+	for {
+		msg := anon.expect(1, 3)
+		if msg[2] == 0 && msg[3] == PLAYER_STATE_JOINED {
+			if string(msg[4:]) != "Ana" {
+				t.Fatalf("host name %q, want the token name", msg[4:])
+			}
+			break
+		}
+	}
+}
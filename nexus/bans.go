@@ -0,0 +1,207 @@
+package nexus
+
+import (
+	crand "crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	melody "github.com/olahol/melody"
+)
+
+// Ban matches clients by remote IP or CIDR, verified user id or UniqueId prefix. A zero
+// ExpiresAt never expires.
+type Ban struct {
+	Id             string    `json:"id"`
+	IP             string    `json:"ip,omitempty"`
+	UserId         string    `json:"user_id,omitempty"`
+	UniqueIdPrefix string    `json:"unique_id_prefix,omitempty"`
+	Reason         string    `json:"reason"`
+	CreatedAt      time.Time `json:"created_at"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+var ErrBanEmpty = errors.New("ban needs an ip, user_id or unique_id_prefix")
+
+// Checks the fields of a ban before it is stored
+func (b *Ban) validate() error {
+	if b.IP == "" && b.UserId == "" && b.UniqueIdPrefix == "" {
+		return ErrBanEmpty
+	}
+	if b.IP != "" && net.ParseIP(b.IP) == nil {
+		if _, _, err := net.ParseCIDR(b.IP); err != nil {
+			return errors.New("ip must be an address or a CIDR")
+		}
+	}
+	return nil
+}
+
+func (b *Ban) expired(now time.Time) bool {
+	return !b.ExpiresAt.IsZero() && now.After(b.ExpiresAt)
+}
+
+// Returns true if the ban applies to a client
+func (b *Ban) matches(ip net.IP, user_id string, unique_id string) bool {
+	if b.IP != "" && ip != nil {
+		if _, cidr, err := net.ParseCIDR(b.IP); err == nil {
+			if cidr.Contains(ip) {
+				return true
+			}
+		} else if net.ParseIP(b.IP).Equal(ip) {
+			return true
+		}
+	}
+	if b.UserId != "" && b.UserId == user_id {
+		return true
+	}
+	return b.UniqueIdPrefix != "" && strings.HasPrefix(unique_id, b.UniqueIdPrefix)
+}
+
+// BanStore keeps the ban list and saves it to a JSON file on every change. A store with an
+// empty path only lives in memory.
+type BanStore struct {
+	mut  sync.RWMutex
+	bans []*Ban
+	path string
+}
+
+func NewBanStore(path string) *BanStore {
+	return &BanStore{path: path, bans: make([]*Ban, 0)}
+}
+
+// Reads the ban file, a missing file is an empty ban list
+func (store *BanStore) Load() error {
+	if store.path == "" {
+		return nil
+	}
+	b, err := os.ReadFile(store.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	bans := make([]*Ban, 0)
+	if err := json.Unmarshal(b, &bans); err != nil {
+		return err
+	}
+	store.mut.Lock()
+	store.bans = bans
+	store.mut.Unlock()
+	return nil
+}
+
+// Writes the ban file, must be called with the lock held
+func (store *BanStore) save() error {
+	if store.path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(store.bans, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := store.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, store.path)
+}
+
+// Stores a ban, assigning its id and creation time
+func (store *BanStore) Add(ban *Ban) error {
+	if err := ban.validate(); err != nil {
+		return err
+	}
+	id := make([]byte, 6)
+	crand.Read(id)
+	ban.Id = hex.EncodeToString(id)
+	ban.CreatedAt = time.Now()
+
+	store.mut.Lock()
+	defer store.mut.Unlock()
+	store.bans = append(store.bans, ban)
+	store.pruneExpired()
+	return store.save()
+}
+
+// Removes a ban by id, returns false if it doesn't exist
+func (store *BanStore) Remove(id string) (bool, error) {
+	store.mut.Lock()
+	defer store.mut.Unlock()
+	for idx, ban := range store.bans {
+		if ban.Id == id {
+			store.bans = append(store.bans[:idx], store.bans[idx+1:]...)
+			return true, store.save()
+		}
+	}
+	return false, nil
+}
+
+// Returns the bans that didn't expire
+func (store *BanStore) List() []Ban {
+	store.mut.RLock()
+	defer store.mut.RUnlock()
+	now := time.Now()
+	bans := make([]Ban, 0)
+	for _, ban := range store.bans {
+		if !ban.expired(now) {
+			bans = append(bans, *ban)
+		}
+	}
+	return bans
+}
+
+// Returns the first active ban matching a client, nil if it isn't banned
+func (store *BanStore) Match(remote_ip string, user_id string, unique_id string) *Ban {
+	ip := net.ParseIP(remote_ip)
+
+	store.mut.RLock()
+	defer store.mut.RUnlock()
+	now := time.Now()
+	for _, ban := range store.bans {
+		if !ban.expired(now) && ban.matches(ip, user_id, unique_id) {
+			return ban
+		}
+	}
+	return nil
+}
+
+// Drops expired bans, must be called with the lock held
+func (store *BanStore) pruneExpired() {
+	now := time.Now()
+	active := store.bans[:0]
+	for _, ban := range store.bans {
+		if !ban.expired(now) {
+			active = append(active, ban)
+		}
+	}
+	store.bans = active
+}
+
+// Returns the IP of the client of a websocket session without the port
+func remoteIP(s *melody.Session) string {
+	host, _, err := net.SplitHostPort(s.Request.RemoteAddr)
+	if err != nil {
+		return s.Request.RemoteAddr
+	}
+	return host
+}
+
+// Returns true and sends ERR_BANNED if a session matches a ban. The caller closes the
+// connection.
+func (hub *Hub) checkBan(s *SessionInfo) bool {
+	conn := s.conn()
+	if hub.Bans == nil || conn == nil {
+		return false
+	}
+	ban := hub.Bans.Match(remoteIP(conn), s.UserId, s.UniqueId)
+	if ban == nil {
+		return false
+	}
+	s.SendError(ERR_BANNED, ban.Reason)
+	return true
+}
@@ -0,0 +1,152 @@
+package nexus
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBanMatches(t *testing.T) {
+	tests := []struct {
+		ban     Ban
+		ip      string
+		user_id string
+		matches bool
+	}{
+		{Ban{IP: "10.0.0.5"}, "10.0.0.5", "", true},
+		{Ban{IP: "10.0.0.5"}, "10.0.0.6", "", false},
+		{Ban{IP: "10.0.0.0/24"}, "10.0.0.200", "", true},
+		{Ban{IP: "10.0.0.0/24"}, "10.0.1.1", "", false},
+		{Ban{IP: "10.0.0.5/32"}, "10.0.0.5", "", true},
+		{Ban{IP: "10.0.0.5"}, "::ffff:10.0.0.5", "", true},
+		{Ban{IP: "2001:db8::/32"}, "2001:db8::1", "", true},
+		{Ban{IP: "2001:db8::/32"}, "2001:db9::1", "", false},
+		{Ban{IP: "2001:db8::1"}, "2001:db8:0::1", "", true},
+		{Ban{IP: "10.0.0.5"}, "", "", false},
+		{Ban{UserId: "u1"}, "10.0.0.5", "u1", true},
+		{Ban{UserId: "u1"}, "10.0.0.5", "u2", false},
+		{Ban{UserId: "u1"}, "10.0.0.5", "", false},
+		{Ban{IP: "10.0.0.0/8", UserId: "u1"}, "192.168.0.1", "u1", true},
+	}
+	for _, tt := range tests {
+		if matches := tt.ban.matches(net.ParseIP(tt.ip), tt.user_id, "abcd"); matches != tt.matches {
+			t.Errorf("%+v with %q %q: matches %v, want %v", tt.ban, tt.ip, tt.user_id, matches, tt.matches)
+		}
+	}
+	prefix := Ban{UniqueIdPrefix: "ab"}
+	if !prefix.matches(nil, "", "abcd") || prefix.matches(nil, "", "bacd") {
+		t.Error("unique id prefix mismatch")
+	}
+}
+
+func TestBanValidate(t *testing.T) {
+	for _, ban := range []Ban{{}, {Reason: "spam"}, {IP: "10.0.0"}, {IP: "10.0.0.0/33"}, {IP: "host.com"}} {
+		if ban.validate() == nil {
+			t.Errorf("%+v accepted", ban)
+		}
+	}
+	for _, ban := range []Ban{{IP: "10.0.0.1"}, {IP: "10.0.0.0/16"}, {IP: "::1"}, {UserId: "u1"}, {UniqueIdPrefix: "ab"}} {
+		if err := ban.validate(); err != nil {
+			t.Errorf("%+v: %v", ban, err)
+		}
+	}
+}
+
+func TestBanExpiry(t *testing.T) {
+	store := NewBanStore("")
+	if err := store.Add(&Ban{IP: "10.0.0.1", ExpiresAt: time.Now().Add(-time.Second)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add(&Ban{IP: "10.0.0.2", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add(&Ban{IP: "10.0.0.3"}); err != nil {
+		t.Fatal(err)
+	}
+	if store.Match("10.0.0.1", "", "") != nil {
+		t.Error("expired ban matched")
+	}
+	if store.Match("10.0.0.2", "", "") == nil || store.Match("10.0.0.3", "", "") == nil {
+		t.Error("active ban didn't match")
+	}
+	if n := len(store.List()); n != 2 {
+		t.Errorf("%d bans listed, want 2", n)
+	}
+	// Add drops the expired bans from the store
+	if n := len(store.bans); n != 2 {
+		t.Errorf("%d bans stored, want 2", n)
+	}
+}
+
+func TestBanStoreFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "bans.json")
+	store := NewBanStore(path)
+	if err := store.Load(); err != nil {
+		t.Fatalf("missing file: %v", err)
+	}
+	ban := &Ban{IP: "10.0.0.0/24", Reason: "spam", ExpiresAt: time.Now().Add(time.Hour).Round(0)}
+	if err := store.Add(ban); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Add(&Ban{UserId: "u1"}); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("ban file %v %v", info, err)
+	}
+
+	loaded := NewBanStore(path)
+	if err := loaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	bans := loaded.List()
+	if len(bans) != 2 {
+		t.Fatalf("%d bans loaded, want 2", len(bans))
+	}
+	if bans[0].Id != ban.Id || bans[0].IP != ban.IP || bans[0].Reason != "spam" || !bans[0].ExpiresAt.Equal(ban.ExpiresAt) {
+		t.Errorf("loaded %+v, want %+v", bans[0], *ban)
+	}
+	if loaded.Match("10.0.0.9", "", "") == nil || loaded.Match("", "u1", "") == nil {
+		t.Error("loaded bans don't match")
+	}
+
+	if ok, err := loaded.Remove(ban.Id); !ok || err != nil {
+		t.Fatalf("remove %v %v", ok, err)
+	}
+	if ok, _ := loaded.Remove(ban.Id); ok {
+		t.Error("ban removed twice")
+	}
+	reloaded := NewBanStore(path)
+	if err := reloaded.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(reloaded.List()); n != 1 {
+		t.Errorf("%d bans after remove, want 1", n)
+	}
+	if _, err := os.Stat(path + ".tmp"); !os.IsNotExist(err) {
+		t.Error("temporary file left behind")
+	}
+
+	if err := os.WriteFile(path, []byte("{"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.Load() == nil {
+		t.Error("invalid ban file loaded")
+	}
+	if n := len(reloaded.List()); n != 1 {
+		t.Errorf("failed load replaced the bans, %d left", n)
+	}
+}
+
+func TestBannedClient(t *testing.T) {
+	store := NewBanStore("")
+	if err := store.Add(&Ban{IP: "127.0.0.0/8", Reason: "spam"}); err != nil {
+		t.Fatal(err)
+	}
+	_, srv := newTestHub(t, WithBanStore(store))
+	c := dialTest(t, srv, "")
+	c.expectError(ERR_BANNED)
+	c.expectClose()
+}
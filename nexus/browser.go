@@ -0,0 +1,110 @@
+package nexus
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"sync/atomic"
+)
+
+// Page size limits of the public room browser
+const (
+	DefaultRoomListPageSize = 20
+	MaxRoomListPageSize     = 100
+)
+
+// RoomListRequest is the payload of HUB_CMD_SC_LIST_ROOMS, pages start at 0
+type RoomListRequest struct {
+	AppName      string `json:"app_name"`
+	Page         int    `json:"page"`
+	PageSize     int    `json:"page_size"`
+	OnlyJoinable bool   `json:"only_joinable"`
+}
+
+// Public information of a room shown in the room browser
+type RoomListing struct {
+	RoomId     string            `json:"room_id"`
+	Title      string            `json:"title"`
+	Players    int               `json:"players"`
+	MaxPlayers int               `json:"max_players"`
+	AllowJoin  bool              `json:"allow_join"`
+	Locked     bool              `json:"locked"` // Joining requires the room secret
+	Topology   string            `json:"topology"`
+	Region     string            `json:"region,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+}
+
+type RoomListPage struct {
+	Page     int           `json:"page"`
+	PageSize int           `json:"page_size"`
+	Total    int           `json:"total"`
+	Rooms    []RoomListing `json:"rooms"`
+}
+
+// Lists the open public rooms of an AppName sorted by creation time. If OnlyJoinable is set,
+// rooms that don't allow joining or are full are left out.
+func (hub *Hub) ListPublicRooms(req *RoomListRequest) RoomListPage {
+	page_size := req.PageSize
+	if page_size <= 0 {
+		page_size = DefaultRoomListPageSize
+	}
+	page_size = min(page_size, MaxRoomListPageSize)
+	page := max(req.Page, 0)
+
+	rooms := make([]*Room, 0)
+	hub.RoomMap.Range(func(key any, value any) bool {
+		room := value.(*Room)
+		if !room.Open.Load() || !room.Public || room.AppName != req.AppName {
+			return true
+		}
+		if req.OnlyJoinable && (!room.AllowJoin.Load() || int(atomic.LoadInt64(&room.PeerCount)) >= len(room.Peers)) {
+			return true
+		}
+		rooms = append(rooms, room)
+		return true
+	})
+	slices.SortFunc(rooms, func(a, b *Room) int {
+		if a.CreationTimestamp == b.CreationTimestamp {
+			return cmp.Compare(a.Name, b.Name)
+		}
+		return cmp.Compare(a.CreationTimestamp, b.CreationTimestamp)
+	})
+
+	result := RoomListPage{
+		Page:     page,
+		PageSize: page_size,
+		Total:    len(rooms),
+		Rooms:    make([]RoomListing, 0),
+	}
+	start := min(page*page_size, len(rooms))
+	end := min(start+page_size, len(rooms))
+	for _, room := range rooms[start:end] {
+		result.Rooms = append(result.Rooms, RoomListing{
+			RoomId:     room.Name,
+			Title:      room.Title,
+			Players:    int(atomic.LoadInt64(&room.PeerCount)),
+			MaxPlayers: len(room.Peers),
+			AllowJoin:  room.AllowJoin.Load(),
+			Locked:     room.Secret != "",
+			Topology:   room.Topology.String(),
+			Region:     room.Region,
+			Metadata:   room.Metadata,
+		})
+	}
+	return result
+}
+
+// Room browser http request handler, the query parameters app_name, page, page_size and
+// only_joinable match the fields of RoomListRequest
+func (hub *Hub) HandleRoomListRequest(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	req := RoomListRequest{AppName: q.Get("app_name")}
+	req.Page, _ = strconv.Atoi(q.Get("page"))
+	req.PageSize, _ = strconv.Atoi(q.Get("page_size"))
+	req.OnlyJoinable, _ = strconv.ParseBool(q.Get("only_joinable"))
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hub.ListPublicRooms(&req))
+}
@@ -0,0 +1,312 @@
+package nexus
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"time"
+)
+
+// Duration is a time.Duration written as a string like "5s" in config files
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// Config holds the settings of a hub and the server running it, it can be loaded from a
+// JSON file
+type Config struct {
+	Listen         string `json:"listen"`
+	AllowAnyOrigin bool   `json:"allow_any_origin"`
+	// Origin patterns allowed to connect, when set allow_any_origin is ignored
+	AllowedOrigins []string `json:"allowed_origins"`
+	// TLS certificate and key files, when set the server only accepts https and wss
+	TLSCert string `json:"tls_cert"`
+	TLSKey  string `json:"tls_key"`
+	// Address of a plain HTTP listener redirecting to https, empty disables it
+	HTTPRedirectListen string `json:"http_redirect_listen"`
+	// max-age of the Strict-Transport-Security header, 0 disables it
+	HSTSMaxAge       Duration `json:"hsts_max_age"`
+	HandshakeTimeout Duration `json:"handshake_timeout"`
+	RoomSlots        int      `json:"room_slots"`
+	MaxRoomPeers     int      `json:"max_room_peers"`
+	HubQueueSize     int      `json:"hub_queue_size"`
+	RoomQueueSize    int      `json:"room_queue_size"`
+	MaxMessageSize   int64    `json:"max_message_size"` // Bytes of an inbound frame
+	QueuePolicy      string   `json:"queue_policy"`     // "drop", "disconnect" or "block"
+	QueueTimeout     Duration `json:"queue_timeout"`
+	// Outbound queue limits of slow peers and the default room policy
+	SlowPeerBytes      int64      `json:"slow_peer_bytes"`
+	SlowPeerAge        Duration   `json:"slow_peer_age"`
+	SlowPeerAction     string     `json:"slow_peer_action"` // "drop", "warn" or "kick"
+	PingInterval       Duration   `json:"ping_interval"`
+	ResumeGracePeriod  Duration   `json:"resume_grace_period"`
+	QuickMatchTimeout  Duration   `json:"quick_match_timeout"`
+	MinProtocolVersion int        `json:"min_protocol_version"`
+	AuthKey            string     `json:"auth_key"`
+	AdminToken         string     `json:"admin_token"`
+	BanFile            string     `json:"ban_file"` // Empty keeps bans in memory
+	RateLimits         RateLimits `json:"rate_limits"`
+	// Log output: "text" or "json", the default level and levels per subsystem
+	LogFormat string            `json:"log_format"`
+	LogLevel  string            `json:"log_level"`
+	LogLevels map[string]string `json:"log_levels"`
+	// Time rooms get to end on their own after a shutdown signal
+	ShutdownDrain Duration             `json:"shutdown_drain"`
+	Apps          map[string]AppConfig `json:"apps"`
+}
+
+// AppConfig holds the policy of an AppName, the key "" of Config.Apps applies to apps
+// without their own entry
+type AppConfig struct {
+	Auth         string   `json:"auth"` // "optional", "join_only" or "required"
+	MaxRoomPeers int      `json:"max_room_peers"`
+	Origins      []string `json:"origins"` // Origin patterns allowed to create or join rooms
+}
+
+var authPolicyNames = map[string]AuthPolicy{
+	"":          AUTH_POLICY_OPTIONAL,
+	"optional":  AUTH_POLICY_OPTIONAL,
+	"join_only": AUTH_POLICY_JOIN_ONLY,
+	"required":  AUTH_POLICY_REQUIRED,
+}
+
+var overflowPolicyNames = map[string]OverflowPolicy{
+	"drop":       OVERFLOW_DROP,
+	"disconnect": OVERFLOW_DISCONNECT,
+	"block":      OVERFLOW_BLOCK,
+}
+
+// Returns the configuration matching the hub defaults
+func DefaultConfig() *Config {
+	return &Config{
+		Listen:             ":7777",
+		AllowAnyOrigin:     true,
+		HandshakeTimeout:   Duration(DefaultHandshakeTimeout),
+		RoomSlots:          DefaultRoomSlots,
+		MaxRoomPeers:       MaxRoomPeers,
+		HubQueueSize:       DefaultHubQueueSize,
+		RoomQueueSize:      DefaultRoomQueueSize,
+		MaxMessageSize:     DefaultMaxMessageSize,
+		QueuePolicy:        "block",
+		QueueTimeout:       Duration(DefaultQueueTimeout),
+		SlowPeerBytes:      DefaultSlowPeerBytes,
+		SlowPeerAge:        Duration(DefaultSlowPeerAge),
+		SlowPeerAction:     "drop",
+		PingInterval:       Duration(DefaultPingInterval),
+		RateLimits:         DefaultRateLimits,
+		QuickMatchTimeout:  Duration(DefaultQuickMatchTimeout),
+		MinProtocolVersion: MIN_PROTOCOL_VERSION,
+		ShutdownDrain:      Duration(30 * time.Second),
+		LogFormat:          "text",
+		LogLevel:           "info",
+		LogLevels:          make(map[string]string),
+		Apps:               make(map[string]AppConfig),
+	}
+}
+
+// Reads a JSON config file over the values of c
+func (c *Config) LoadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(b, c); err != nil {
+		return fmt.Errorf("config %s: %w", path, err)
+	}
+	return nil
+}
+
+// Checks that every value is in range, all the problems found are returned joined
+func (c *Config) Validate() error {
+	var errs []error
+	if c.Listen == "" {
+		errs = append(errs, errors.New("listen: address required"))
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		errs = append(errs, errors.New("tls_cert, tls_key: both files are required"))
+	}
+	if c.TLSCert == "" && (c.HTTPRedirectListen != "" || c.HSTSMaxAge != 0) {
+		errs = append(errs, errors.New("http_redirect_listen, hsts_max_age: need tls_cert and tls_key"))
+	}
+	if c.HSTSMaxAge < 0 {
+		errs = append(errs, errors.New("hsts_max_age: can't be negative"))
+	}
+	if c.HandshakeTimeout <= 0 {
+		errs = append(errs, errors.New("handshake_timeout: must be positive"))
+	}
+	if c.RoomSlots <= 0 {
+		errs = append(errs, errors.New("room_slots: must be positive"))
+	}
+	if c.MaxRoomPeers < 1 || c.MaxRoomPeers > MaxRoomPeers {
+		errs = append(errs, fmt.Errorf("max_room_peers: must be between 1 and %d", MaxRoomPeers))
+	}
+	if c.HubQueueSize <= 0 {
+		errs = append(errs, errors.New("hub_queue_size: must be positive"))
+	}
+	if c.RoomQueueSize <= 0 {
+		errs = append(errs, errors.New("room_queue_size: must be positive"))
+	}
+	if c.MaxMessageSize <= 0 {
+		errs = append(errs, errors.New("max_message_size: must be positive"))
+	}
+	if _, ok := overflowPolicyNames[c.QueuePolicy]; !ok {
+		errs = append(errs, fmt.Errorf("queue_policy: unknown policy %q", c.QueuePolicy))
+	}
+	if c.QueueTimeout <= 0 {
+		errs = append(errs, errors.New("queue_timeout: must be positive"))
+	}
+	if c.SlowPeerBytes < 0 || c.SlowPeerAge < 0 {
+		errs = append(errs, errors.New("slow_peer_bytes, slow_peer_age: can't be negative"))
+	}
+	if _, ok := slowPeerActionNames[c.SlowPeerAction]; !ok {
+		errs = append(errs, fmt.Errorf("slow_peer_action: unknown action %q", c.SlowPeerAction))
+	}
+	if c.PingInterval < 0 {
+		errs = append(errs, errors.New("ping_interval: can't be negative"))
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("log_format: unknown format %q", c.LogFormat))
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(c.LogLevel)); err != nil {
+		errs = append(errs, fmt.Errorf("log_level: %w", err))
+	}
+	for name, value := range c.LogLevels {
+		if !slices.Contains(LogSubsystems, name) {
+			errs = append(errs, fmt.Errorf("log_levels: unknown subsystem %q", name))
+		} else if err := level.UnmarshalText([]byte(value)); err != nil {
+			errs = append(errs, fmt.Errorf("log_levels[%q]: %w", name, err))
+		}
+	}
+	limits := c.RateLimits
+	if limits.SessionPackets < 0 || limits.SessionBytes < 0 || limits.RoomPackets < 0 || limits.RoomBytes < 0 {
+		errs = append(errs, errors.New("rate_limits: rates can't be negative"))
+	}
+	if limits.Burst < 1 {
+		errs = append(errs, errors.New("rate_limits.burst: must be at least 1"))
+	}
+	if limits.Warnings < 0 || limits.Warnings > 255 {
+		errs = append(errs, errors.New("rate_limits.warnings: must be between 0 and 255"))
+	}
+	if c.ResumeGracePeriod < 0 {
+		errs = append(errs, errors.New("resume_grace_period: can't be negative"))
+	}
+	if c.QuickMatchTimeout <= 0 {
+		errs = append(errs, errors.New("quick_match_timeout: must be positive"))
+	}
+	if c.ShutdownDrain < 0 {
+		errs = append(errs, errors.New("shutdown_drain: can't be negative"))
+	}
+	if c.MinProtocolVersion < 1 || c.MinProtocolVersion > PROTOCOL_VERSION {
+		errs = append(errs, fmt.Errorf("min_protocol_version: must be between 1 and %d", PROTOCOL_VERSION))
+	}
+	for _, pattern := range c.AllowedOrigins {
+		if err := ValidateOriginPattern(pattern); err != nil {
+			errs = append(errs, fmt.Errorf("allowed_origins: %q: %w", pattern, err))
+		}
+	}
+	for name, app := range c.Apps {
+		for _, pattern := range app.Origins {
+			if err := ValidateOriginPattern(pattern); err != nil {
+				errs = append(errs, fmt.Errorf("apps[%q].origins: %q: %w", name, pattern, err))
+			}
+		}
+		policy, ok := authPolicyNames[app.Auth]
+		if !ok {
+			errs = append(errs, fmt.Errorf("apps[%q].auth: unknown policy %q", name, app.Auth))
+		} else if policy != AUTH_POLICY_OPTIONAL && c.AuthKey == "" {
+			errs = append(errs, fmt.Errorf("apps[%q].auth: policy %q needs auth_key", name, app.Auth))
+		}
+		if app.MaxRoomPeers < 0 || app.MaxRoomPeers > c.MaxRoomPeers {
+			errs = append(errs, fmt.Errorf("apps[%q].max_room_peers: must be between 0 and %d", name, c.MaxRoomPeers))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Returns the hub options of the configuration, c must be valid
+func (c *Config) Options() []Option {
+	opts := []Option{
+		WithRoomSlots(c.RoomSlots),
+		WithMaxRoomPeers(c.MaxRoomPeers),
+		WithHubQueueSize(c.HubQueueSize),
+		WithRoomQueueSize(c.RoomQueueSize),
+		WithHandshakeTimeout(time.Duration(c.HandshakeTimeout)),
+		WithResumeGracePeriod(time.Duration(c.ResumeGracePeriod)),
+		WithQuickMatchTimeout(time.Duration(c.QuickMatchTimeout)),
+		WithMinProtocolVersion(c.MinProtocolVersion),
+		WithMaxMessageSize(c.MaxMessageSize),
+		WithRateLimits(c.RateLimits),
+		WithQueuePolicy(overflowPolicyNames[c.QueuePolicy], time.Duration(c.QueueTimeout)),
+		WithSlowPeerLimits(c.SlowPeerBytes, time.Duration(c.SlowPeerAge), slowPeerActionNames[c.SlowPeerAction]),
+		WithPingInterval(time.Duration(c.PingInterval)),
+	}
+	opts = append(opts, WithLogHandler(c.NewLogHandler(os.Stderr)))
+	var level slog.Level
+	level.UnmarshalText([]byte(c.LogLevel))
+	opts = append(opts, WithLogLevel("", level))
+	for name, value := range c.LogLevels {
+		level.UnmarshalText([]byte(value))
+		opts = append(opts, WithLogLevel(name, level))
+	}
+	if len(c.AllowedOrigins) > 0 {
+		opts = append(opts, WithAllowedOrigins(c.AllowedOrigins...))
+	} else if !c.AllowAnyOrigin {
+		//websocket.Upgrader checks for same origin requests if CheckOrigin is nil
+		opts = append(opts, WithCheckOrigin(nil))
+	}
+	if c.AuthKey != "" {
+		opts = append(opts, WithAuthKey([]byte(c.AuthKey)))
+	}
+	if c.AdminToken != "" {
+		opts = append(opts, WithAdminToken(c.AdminToken))
+	}
+	for name, app := range c.Apps {
+		opts = append(opts, WithAuthPolicy(name, authPolicyNames[app.Auth]))
+		opts = append(opts, WithAppMaxRoomPeers(name, app.MaxRoomPeers))
+		opts = append(opts, WithAppOrigins(name, app.Origins...))
+	}
+	return opts
+}
+
+// Returns a text or JSON log handler writing to w. It accepts every level, the hub filters
+// records by subsystem.
+func (c *Config) NewLogHandler(w io.Writer) slog.Handler {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	if c.LogFormat == "json" {
+		return slog.NewJSONHandler(w, opts)
+	}
+	return slog.NewTextHandler(w, opts)
+}
+
+// Returns the configuration as indented JSON with secrets redacted
+func (c *Config) String() string {
+	redacted := *c
+	if redacted.AuthKey != "" {
+		redacted.AuthKey = "REDACTED"
+	}
+	if redacted.AdminToken != "" {
+		redacted.AdminToken = "REDACTED"
+	}
+	b, _ := json.MarshalIndent(&redacted, "", "  ")
+	return string(b)
+}
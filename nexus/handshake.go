@@ -0,0 +1,109 @@
+package nexus
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// Protocol versions spoken by the server. Clients older than the hub's MinProtocolVersion
+// are rejected in the handshake.
+const (
+	PROTOCOL_VERSION     = 2
+	MIN_PROTOCOL_VERSION = 2
+)
+
+// Default time a client has to send the hello packet after the websocket upgrade
+const DefaultHandshakeTimeout = 5 * time.Second
+
+// Optional protocol features a client can ask for in the hello packet
+var ServerFeatures = []string{
+	"error_codes",
+	"host_migration",
+	"resume",
+	"room_browser",
+	"quick_match",
+	"multicast",
+	"topology",
+	"latency",
+}
+
+// HelloRequest is the payload of the PACKET_HELLO sent by clients before any other packet
+type HelloRequest struct {
+	ProtocolVersion int      `json:"protocol_version"`
+	AppName         string   `json:"app_name"`
+	ClientBuild     string   `json:"client_build"`
+	Capabilities    []string `json:"capabilities"`
+	// Optional auth token, it can also be sent in the token query parameter of /ws
+	Token string `json:"token"`
+}
+
+// HelloReply is sent in a MSG_WELCOME packet when the handshake succeeds
+type HelloReply struct {
+	ProtocolVersion int      `json:"protocol_version"`
+	UniqueId        string   `json:"unique_id"`
+	Features        []string `json:"features"`
+	UserId          string   `json:"user_id,omitempty"`
+}
+
+// Processes the hello packet of a session. Clients with an unsupported protocol version get
+// ERR_PROTOCOL_VERSION and are disconnected.
+func (hub *Hub) handleHello(s *SessionInfo, msg []byte) {
+	if s.Handshaked {
+		return
+	}
+	req := HelloRequest{}
+	if err := json.Unmarshal(msg, &req); err != nil {
+		s.malformedPacket(err)
+		return
+	}
+	if req.ProtocolVersion < hub.MinProtocolVersion || req.ProtocolVersion > PROTOCOL_VERSION {
+		s.SendError(ERR_PROTOCOL_VERSION, fmt.Sprintf("min=%d max=%d", hub.MinProtocolVersion, PROTOCOL_VERSION))
+		s.disconnect()
+		return
+	}
+
+	features := make([]string, 0)
+	for _, f := range req.Capabilities {
+		if slices.Contains(ServerFeatures, f) && !slices.Contains(features, f) {
+			features = append(features, f)
+		}
+	}
+	s.ProtocolVersion = req.ProtocolVersion
+	s.AppName = req.AppName
+	s.ClientBuild = req.ClientBuild
+	if !hub.authenticate(s, req.Token) || hub.checkBan(s) {
+		s.disconnect()
+		return
+	}
+	s.Features = features
+	s.Handshaked = true
+	hub.PendingClients.Delete(s)
+
+	reply, _ := json.Marshal(HelloReply{
+		ProtocolVersion: PROTOCOL_VERSION,
+		UniqueId:        s.UniqueId,
+		Features:        features,
+		UserId:          s.UserId,
+	})
+	s.SendPacket(buildMsgPacket(MSG_WELCOME, 0, string(reply)))
+}
+
+// Closes the connections that didn't complete the handshake in time, handleHello removes
+// the others from PendingClients. Must be called from the hub goroutine.
+func (hub *Hub) checkPendingClients(current_time uint64) {
+	timeout_ms := uint64(hub.HandshakeTimeout.Milliseconds())
+	hub.PendingClients.Range(func(key any, b any) bool {
+		s := key.(*SessionInfo)
+		if s.conn() == nil {
+			hub.PendingClients.Delete(s)
+		} else if s.ConnectionTimestampMS+timeout_ms < current_time {
+			hub.PendingClients.Delete(s)
+			atomic.AddInt64(&hub.Stats.HandshakeTimeouts, 1)
+			s.disconnect()
+		}
+		return true
+	})
+}
@@ -0,0 +1,725 @@
+package nexus
+
+import (
+	"cmp"
+	crand "crypto/rand"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	melody "github.com/olahol/melody"
+	"golang.org/x/exp/rand"
+)
+
+// The struct Hub contains all clients and room information of a server. Wg (WaitingGroup) is
+// not used because the function HubGorroutine keeps running while the hub is active to process
+// events
+type Hub struct {
+	Mut            sync.Mutex
+	Rooms          []*Room
+	RoomMap        sync.Map
+	Wg             sync.WaitGroup
+	UserPacketChan chan (UserPacket)
+	CmdChan        chan (HubChanCmd)
+	SessionMap     sync.Map
+	PendingClients sync.Map // Sessions that didn't send the hello packet yet
+	ClientCount    int64
+	RoomCount      int64
+	Stats          HubStats
+	Metrics        HubMetrics
+	SessionIds     sync.Map
+	ResumeTokens   sync.Map
+	Melody         *melody.Melody
+	ErrorMessages  map[ErrorCode]string
+	MaxRoomPeers   int
+	// Oldest protocol version accepted in the handshake and time given to send it
+	MinProtocolVersion int
+	HandshakeTimeout   time.Duration
+	// HS256 key of auth tokens
+	AuthKey []byte
+	// Bearer token of the admin API, empty disables it
+	AdminToken string
+	Bans       *BanStore
+	// Origin patterns allowed to open websockets, empty leaves Melody.Upgrader.CheckOrigin
+	AllowedOrigins []string
+	// Policies of each AppName, "" is the policy of apps without one
+	AppPolicies map[string]AppPolicy
+	// Buffer sizes of the hub and room channels
+	HubQueueSize  int
+	RoomQueueSize int
+	// What happens to client packets when a queue is full
+	QueuePolicy  OverflowPolicy
+	QueueTimeout time.Duration
+	RateLimits   RateLimits
+	// Outbound queue limits past which a peer is slow and the default room policy
+	SlowPeerBytes  int64
+	SlowPeerAge    time.Duration
+	SlowPeerAction SlowPeerAction
+	// Interval of the server pings and the latency reports sent to hosts, 0 disables them
+	PingInterval time.Duration
+	// Time a disconnected peer keeps its slot waiting for a resume request, 0 disables
+	// session resuming
+	ResumeGracePeriod time.Duration
+	// Quick-match queues keyed by AppName and match parameters, owned by the hub goroutine
+	MatchQueues       map[string][]*matchTicket
+	QuickMatchTimeout time.Duration
+	// Log output, default level and per subsystem levels
+	LogHandler   slog.Handler
+	LogLevel     slog.Level
+	LogLevels    map[string]slog.Level
+	loggers      map[string]*slog.Logger
+	done         chan struct{}
+	stopOnce     sync.Once
+	shuttingDown atomic.Bool
+}
+
+// Stats of a running hub
+type HubStats struct {
+	RoomCreations     int64
+	RoomJoins         int64
+	ClientConnections int64
+	HandshakeTimeouts int64
+	// Websocket upgrades and room requests refused for their origin
+	OriginRejections int64
+	// Packets rejected by validation and frames over the size limit, their sessions
+	// are disconnected
+	MalformedPackets int64
+	OversizedFrames  int64
+	// Packets dropped by session and room rate limits, warnings sent and sessions
+	// disconnected for flooding
+	RateLimitedPackets   int64
+	RateLimitWarnings    int64
+	RateLimitDisconnects int64
+	// Outbound messages dropped, peers that fell behind and peers kicked for it
+	OutboundDrops int64
+	SlowPeers     int64
+	SlowPeerKicks int64
+}
+
+// IDs for network packets processed by the hub
+const (
+	HUB_CMD_SC_CREATE_ROOM = iota
+	HUB_CMD_SC_JOIN_ROOM
+	HUB_CMD_SC_RESUME_SESSION
+	HUB_CMD_SC_LIST_ROOMS
+	HUB_CMD_SC_QUICK_MATCH
+	HUB_CMD_SC_QUICK_MATCH_CANCEL
+)
+
+// Ids for commands sent using hub.CmdChan channel
+const (
+	HUB_CHAN_CMD_ROOM_UNREGISTER = iota
+	HUB_CHAN_CMD_NEW_CLIENT
+	HUB_CHAN_CMD_KICK_SESSION
+	HUB_CHAN_CMD_CLOSE_ROOM
+	HUB_CHAN_CMD_SET_ALLOW_JOIN
+	HUB_CHAN_CMD_ROOM_MESSAGE
+)
+
+// HubChanCmd contains parameters for the hub event channel read inside the function HubGorroutine
+type HubChanCmd struct {
+	Id      int
+	Session *SessionInfo
+	Room    *Room
+	IntVal  int
+	Name    string // Room name or session UniqueId of admin commands
+	Text    string
+}
+
+// Default number of simultaneous rooms a hub can hold and buffer sizes of its channels
+const (
+	DefaultRoomSlots     = 1024
+	DefaultHubQueueSize  = 32
+	DefaultRoomQueueSize = 128
+)
+
+// AppPolicy holds the settings of the rooms of an AppName
+type AppPolicy struct {
+	Auth         AuthPolicy
+	MaxRoomPeers int      // 0 uses the hub's MaxRoomPeers
+	Origins      []string // Origin patterns allowed to create or join rooms, empty allows all
+}
+
+// Returns the policy of an AppName, apps without policy use the one registered for ""
+func (hub *Hub) appPolicy(app_name string) AppPolicy {
+	if policy, ok := hub.AppPolicies[app_name]; ok {
+		return policy
+	}
+	return hub.AppPolicies[""]
+}
+
+// Returns the max_players limit of the rooms of an AppName
+func (hub *Hub) maxRoomPeers(app_name string) int {
+	if n := hub.appPolicy(app_name).MaxRoomPeers; n > 0 {
+		return min(n, hub.MaxRoomPeers)
+	}
+	return hub.MaxRoomPeers
+}
+
+// NewHub creates a hub configured with opts. The hub's goroutine is not running until
+// Start is called.
+func NewHub(opts ...Option) *Hub {
+	hub := &Hub{
+		Mut:                sync.Mutex{},
+		Rooms:              make([]*Room, DefaultRoomSlots),
+		Melody:             melody.New(),
+		MaxRoomPeers:       MaxRoomPeers,
+		ErrorMessages:      DefaultErrorMessages,
+		MinProtocolVersion: MIN_PROTOCOL_VERSION,
+		HandshakeTimeout:   DefaultHandshakeTimeout,
+		AppPolicies:        make(map[string]AppPolicy),
+		Bans:               NewBanStore(""),
+		HubQueueSize:       DefaultHubQueueSize,
+		RoomQueueSize:      DefaultRoomQueueSize,
+		RateLimits:         DefaultRateLimits,
+		QueuePolicy:        OVERFLOW_BLOCK,
+		QueueTimeout:       DefaultQueueTimeout,
+		SlowPeerBytes:      DefaultSlowPeerBytes,
+		SlowPeerAge:        DefaultSlowPeerAge,
+		PingInterval:       DefaultPingInterval,
+		MatchQueues:        make(map[string][]*matchTicket),
+		QuickMatchTimeout:  DefaultQuickMatchTimeout,
+		LogLevel:           slog.LevelInfo,
+		LogLevels:          make(map[string]slog.Level),
+		done:               make(chan struct{}),
+	}
+	hub.Melody.Config.MaxMessageSize = DefaultMaxMessageSize
+	for _, opt := range opts {
+		opt(hub)
+	}
+	hub.UserPacketChan = make(chan UserPacket, hub.HubQueueSize)
+	hub.CmdChan = make(chan HubChanCmd, hub.HubQueueSize)
+	hub.setupLoggers()
+	hub.setupMelody()
+	return hub
+}
+
+//go:embed hubstats.html
+var hubListTemplateSource string
+
+var hubListTemplate = template.Must(template.New("Name").Parse(hubListTemplateSource))
+
+// Room data shown in the /list page and the admin API
+type RoomInfo struct {
+	Name        string       `json:"name"`
+	AppName     string       `json:"app_name"`
+	Time        int64        `json:"time"` // Seconds since creation
+	Players     int          `json:"players"`
+	MaxPlayers  int          `json:"max_players"`
+	AllowJoin   bool         `json:"allow_join"`
+	Public      bool         `json:"public"`
+	Topology    string       `json:"topology"`
+	PacketsIn   int64        `json:"packets_in"`
+	PacketsOut  int64        `json:"packets_out"`
+	BytesIn     int64        `json:"bytes_in"`
+	BytesOut    int64        `json:"bytes_out"`
+	RateLimited int64        `json:"rate_limited"`
+	MulticastIn int64        `json:"multicast_in"`
+	Peers       []ClientInfo `json:"peers,omitempty"`
+}
+
+// Client data shown in the /list page and the admin API
+type ClientInfo struct {
+	UniqueId     string `json:"unique_id"`
+	Name         string `json:"name"`
+	RoomName     string `json:"room_name"`
+	AppName      string `json:"app_name"`
+	PeerId       int    `json:"peer_id"`
+	IsHost       bool   `json:"is_host"`
+	UserId       string `json:"user_id,omitempty"`
+	RemoteAddr   string `json:"remote_addr"`
+	PacketsIn    int64  `json:"packets_in"`
+	PacketsOut   int64  `json:"packets_out"`
+	BytesIn      int64  `json:"bytes_in"`
+	BytesOut     int64  `json:"bytes_out"`
+	RateLimited  int64  `json:"rate_limited"`
+	RateWarnings int64  `json:"rate_warnings"`
+	// Outbound queue of the websocket connection
+	QueuedPackets int   `json:"queued_packets"`
+	QueuedBytes   int64 `json:"queued_bytes"`
+	QueueAgeMS    int64 `json:"queue_age_ms"`
+	OutDrops      int64 `json:"out_drops"`
+	// Smoothed round trip time and jitter of the server pings
+	RttMS    float64 `json:"rtt_ms"`
+	JitterMS float64 `json:"jitter_ms"`
+}
+
+// Server and hub stats shown in the /list page and the admin API
+type SysInfo struct {
+	HeapAllocMB       float64 `json:"heap_alloc_mb"`
+	TotalAllocMB      float64 `json:"total_alloc_mb"`
+	SysMemMB          float64 `json:"sys_mem_mb"`
+	NumGC             uint32  `json:"num_gc"`
+	ClientsCount      int64   `json:"clients_count"`
+	RoomsCount        int64   `json:"rooms_count"`
+	RoomCreations     int64   `json:"room_creations"`
+	RoomJoins         int64   `json:"room_joins"`
+	ClientConnections int64   `json:"client_connections"`
+	HandshakeTimeouts int64   `json:"handshake_timeouts"`
+	OriginRejections  int64   `json:"origin_rejections"`
+	MalformedPackets  int64   `json:"malformed_packets"`
+	OversizedFrames   int64   `json:"oversized_frames"`
+	RateLimited       int64   `json:"rate_limited"`
+	RateWarnings      int64   `json:"rate_warnings"`
+	RateDisconnects   int64   `json:"rate_disconnects"`
+	OutboundDrops     int64   `json:"outbound_drops"`
+	SlowPeers         int64   `json:"slow_peers"`
+	SlowPeerKicks     int64   `json:"slow_peer_kicks"`
+}
+
+// Returns the rooms of the hub sorted by name
+func (hub *Hub) collectRooms() []RoomInfo {
+	roomArr := make([]RoomInfo, 0)
+	time_now_unix := time.Now().UnixMilli()
+	hub.RoomMap.Range(func(key any, value any) bool {
+		room := value.(*Room)
+		roomArr = append(roomArr, RoomInfo{
+			Name:        room.Name,
+			AppName:     room.AppName,
+			Time:        (time_now_unix - room.CreationTimestamp) / int64(1000),
+			Players:     int(atomic.LoadInt64(&room.PeerCount)),
+			MaxPlayers:  len(room.Peers),
+			AllowJoin:   room.AllowJoin.Load(),
+			Public:      room.Public,
+			Topology:    room.Topology.String(),
+			PacketsIn:   atomic.LoadInt64(&room.Stats.PacketsIn),
+			PacketsOut:  atomic.LoadInt64(&room.Stats.PacketsOut),
+			BytesIn:     atomic.LoadInt64(&room.Stats.BytesIn),
+			BytesOut:    atomic.LoadInt64(&room.Stats.BytesOut),
+			RateLimited: atomic.LoadInt64(&room.Stats.RateLimited),
+			MulticastIn: atomic.LoadInt64(&room.Stats.MulticastIn),
+		})
+		return true
+	})
+	slices.SortFunc(roomArr, func(a, b RoomInfo) int {
+		return cmp.Compare[string](a.Name, b.Name)
+	})
+	return roomArr
+}
+
+// Returns the clients of the hub sorted by room and name
+func (hub *Hub) collectClients() []ClientInfo {
+	clientsArr := make([]ClientInfo, 0)
+	hub.SessionMap.Range(func(k any, v any) bool {
+		cli := v.(*SessionInfo)
+		cliInfo := ClientInfo{
+			UniqueId:     cli.UniqueId,
+			Name:         cli.Name,
+			AppName:      cli.AppName,
+			PeerId:       cli.PeerId,
+			IsHost:       cli.IsHost,
+			UserId:       cli.UserId,
+			RemoteAddr:   k.(*melody.Session).RemoteAddr().String(),
+			BytesIn:      atomic.LoadInt64(&cli.Stats.BytesIn),
+			BytesOut:     atomic.LoadInt64(&cli.Stats.BytesOut),
+			PacketsIn:    atomic.LoadInt64(&cli.Stats.PacketsIn),
+			PacketsOut:   atomic.LoadInt64(&cli.Stats.PacketsOut),
+			RateLimited:  atomic.LoadInt64(&cli.Stats.RateLimited),
+			RateWarnings: atomic.LoadInt64(&cli.Stats.RateWarnings),
+		}
+		packets, bytes, age := cli.OutQueue()
+		cliInfo.QueuedPackets = packets
+		cliInfo.QueuedBytes = bytes
+		cliInfo.QueueAgeMS = age.Milliseconds()
+		cliInfo.OutDrops = atomic.LoadInt64(&cli.Stats.OutDrops)
+		cliInfo.RttMS, cliInfo.JitterMS = cli.Latency()
+		if room := cli.getRoom(); room != nil {
+			cliInfo.RoomName = room.Name
+		}
+		clientsArr = append(clientsArr, cliInfo)
+		return true
+	})
+	slices.SortFunc(clientsArr, func(a, b ClientInfo) int {
+		if a.RoomName == b.RoomName {
+			return cmp.Compare(a.Name, b.Name)
+		} else {
+			return cmp.Compare(a.RoomName, b.RoomName)
+
+		}
+	})
+	return clientsArr
+}
+
+// Returns memory and hub stats
+func (hub *Hub) collectSysInfo() SysInfo {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return SysInfo{
+		HeapAllocMB:       ToMBf(m.HeapAlloc),
+		TotalAllocMB:      ToMBf(m.TotalAlloc),
+		SysMemMB:          ToMBf(m.Sys),
+		NumGC:             m.NumGC,
+		ClientsCount:      atomic.LoadInt64(&hub.ClientCount),
+		RoomsCount:        atomic.LoadInt64(&hub.RoomCount),
+		RoomCreations:     atomic.LoadInt64(&hub.Stats.RoomCreations),
+		RoomJoins:         atomic.LoadInt64(&hub.Stats.RoomJoins),
+		ClientConnections: atomic.LoadInt64(&hub.Stats.ClientConnections),
+		HandshakeTimeouts: atomic.LoadInt64(&hub.Stats.HandshakeTimeouts),
+		OriginRejections:  atomic.LoadInt64(&hub.Stats.OriginRejections),
+		MalformedPackets:  atomic.LoadInt64(&hub.Stats.MalformedPackets),
+		OversizedFrames:   atomic.LoadInt64(&hub.Stats.OversizedFrames),
+		RateLimited:       atomic.LoadInt64(&hub.Stats.RateLimitedPackets),
+		RateWarnings:      atomic.LoadInt64(&hub.Stats.RateLimitWarnings),
+		RateDisconnects:   atomic.LoadInt64(&hub.Stats.RateLimitDisconnects),
+		OutboundDrops:     atomic.LoadInt64(&hub.Stats.OutboundDrops),
+		SlowPeers:         atomic.LoadInt64(&hub.Stats.SlowPeers),
+		SlowPeerKicks:     atomic.LoadInt64(&hub.Stats.SlowPeerKicks),
+	}
+}
+
+// System Info http request handler. Lists server stats, memory, rooms and client data
+func (hub *Hub) HandleHubListRequest(w http.ResponseWriter, r *http.Request) {
+	hubListTemplate.Execute(w, map[string]any{
+		"rooms":   hub.collectRooms(),
+		"clients": hub.collectClients(),
+		"stats":   hub.collectSysInfo(),
+	})
+}
+
+// Main hub event handler. All hub members can be modified in this loop as a thread-safety
+// constraint to keep concurrency bugs away.
+func (hub *Hub) HubGorroutine() {
+	defer func() {
+		if r := recover(); r != nil {
+			hub.logger(LOG_HUB).Error("hub goroutine panic", "error", r, "stack", string(debug.Stack()))
+		}
+	}()
+	client_check_timer := time.NewTicker(1 * time.Second)
+	defer client_check_timer.Stop()
+	for {
+		select {
+		case usrpck := <-hub.UserPacketChan:
+			hub.HandlePacket(usrpck.SessionI, usrpck.Msg)
+		case <-hub.done:
+			return
+		case chanmsg := <-hub.CmdChan:
+			if chanmsg.Id == HUB_CHAN_CMD_ROOM_UNREGISTER {
+				//free resources from hub
+				hub.RoomMap.Delete(chanmsg.Room.Name)
+				hub.Rooms[chanmsg.Room.Id] = nil
+			} else {
+				hub.handleAdminCmd(chanmsg)
+			}
+		case <-client_check_timer.C:
+			current_time := GetUnixTimestampMS()
+			hub.checkPendingClients(current_time)
+			hub.expireMatchTickets(current_time)
+		}
+	}
+
+}
+
+// Registers a client connection as a hub's session
+func (hub *Hub) RegisterClient(session *SessionInfo) {
+	hub.SessionMap.Store(session.Session, session)
+	hub.PendingClients.Store(session, true)
+	atomic.AddInt64(&hub.ClientCount, 1)
+	atomic.AddInt64(&hub.Stats.ClientConnections, 1)
+	hub.setRandomClientId(session)
+	session.log().Debug("client registered")
+}
+
+// Unregisters a client connection in the hub, sessions already unregistered are ignored
+func (hub *Hub) UnregisterClient(session *SessionInfo) {
+	if hub == nil {
+		return
+	}
+	if _, conn, ok := session.unregister(false); ok {
+		hub.forgetClient(session, conn)
+	}
+}
+
+// Removes an unregistered session from the hub, conn is the connection it had
+func (hub *Hub) forgetClient(session *SessionInfo, conn *melody.Session) {
+	session.log().Debug("client unregistered", "name", session.Name)
+	atomic.AddInt64(&hub.ClientCount, -1)
+	hub.PendingClients.Delete(session)
+	if conn != nil {
+		hub.SessionMap.Delete(conn)
+	}
+	hub.SessionIds.Delete(session.UniqueId)
+	if session.ResumeToken != "" {
+		hub.ResumeTokens.Delete(session.ResumeToken)
+	}
+}
+
+func ToMBf(val uint64) float64 {
+	return float64(val) / 1024.0 / 1024.0
+}
+
+// Processes a roomRequest struct to join a client to a room.
+func (hub *Hub) joinRoomRequest(session *SessionInfo, roomReq *RoomRequest) bool {
+	//
+	if session.getRoom() != nil {
+		session.SendError(ERR_ALREADY_IN_ROOM, roomReq.RoomId)
+		return false
+	}
+	value, _ := hub.RoomMap.Load(roomReq.RoomId)
+	if roomReq.RoomId == "" || value == nil || value.(*Room) == nil {
+		session.SendError(ERR_ROOM_NOT_FOUND, roomReq.RoomId)
+		return false
+	}
+	room := value.(*Room)
+
+	if room.AppName != roomReq.AppName {
+		session.SendError(ERR_APP_MISMATCH, roomReq.RoomId)
+		return false
+	}
+	if !hub.authorizeRoom(session, room.AppName, false) {
+		return false
+	}
+	if hub.checkBan(session) {
+		session.disconnect()
+		return false
+	}
+	if !room.AllowJoin.Load() {
+		session.SendError(ERR_JOIN_DISABLED, roomReq.RoomId)
+		return false
+	}
+
+	if room.Secret != roomReq.RoomSecret {
+		session.SendError(ERR_INVALID_PASSWORD, roomReq.RoomId)
+		return false
+	}
+
+	if !room.Open.Load() {
+		session.SendError(ERR_ROOM_CLOSED, roomReq.RoomId)
+		return false
+	}
+	if !room.offerCmd(RoomChanCmd{
+		Id:      ROOM_CHAN_CMD_USER_JOIN,
+		Session: session,
+		RoomReq: roomReq,
+	}) {
+		session.SendError(ERR_ROOM_CLOSED, roomReq.RoomId)
+		return false
+	}
+	atomic.AddInt64(&hub.Stats.RoomJoins, 1)
+	return true
+}
+
+// Creates a random room name, a string of 3 characters that doesn't is not currently registered
+// as a existing room.
+func (hub *Hub) getRandomRoomName(room *Room) {
+	ch := "0123456789abcdefghjkmnABCDEFGHJKLMN"
+	rand.Seed(uint64(time.Now().UnixNano()))
+	for {
+		rndstr := string(ch[rand.Intn(len(ch))]) + string(ch[rand.Intn(len(ch))]) + string(ch[rand.Intn(len(ch))])
+		if _, loaded := hub.RoomMap.LoadOrStore(rndstr, room); !loaded {
+			room.Name = rndstr
+			return
+		}
+	}
+}
+
+// Creates the token a client uses to take back its peer slot after a disconnection. Tokens
+// are only issued if the hub has a resume grace period.
+func (hub *Hub) issueResumeToken(session *SessionInfo) {
+	if hub.ResumeGracePeriod <= 0 {
+		return
+	}
+	b := make([]byte, 12)
+	crand.Read(b)
+	session.ResumeToken = session.UniqueId + "." + hex.EncodeToString(b)
+	hub.ResumeTokens.Store(session.ResumeToken, session)
+	session.SendPacket(buildMsgPacket(MSG_RESUME_TOKEN, 0, session.ResumeToken))
+}
+
+// Processes a roomRequest with a resume token, the room reattaches the connection to the
+// disconnected session
+func (hub *Hub) resumeSessionRequest(session *SessionInfo, roomReq *RoomRequest) bool {
+	value, _ := hub.ResumeTokens.Load(roomReq.ResumeToken)
+	old, _ := value.(*SessionInfo)
+	var old_room *Room
+	if old != nil {
+		old_room = old.getRoom()
+	}
+	if roomReq.ResumeToken == "" || old_room == nil || session.getRoom() != nil {
+		session.SendError(ERR_SESSION_EXPIRED, "")
+		return false
+	}
+	if !old_room.offerCmd(RoomChanCmd{
+		Id:         ROOM_CHAN_CMD_USER_RESUME,
+		Session:    old,
+		NewSession: session,
+	}) {
+		session.SendError(ERR_SESSION_EXPIRED, "")
+		return false
+	}
+	return true
+}
+
+func (hub *Hub) setRandomClientId(conn *SessionInfo) {
+	ch := "0123456789abcdefghjkmnABCDEFGHJKLMN"
+	rand.Seed(uint64(time.Now().UnixNano()))
+	for {
+		rndstr := string(ch[rand.Intn(len(ch))]) + string(ch[rand.Intn(len(ch))]) + string(ch[rand.Intn(len(ch))]) + string(ch[rand.Intn(len(ch))])
+		if _, loaded := hub.SessionIds.LoadOrStore(rndstr, conn); !loaded {
+			conn.UniqueId = rndstr
+			return
+		}
+	}
+}
+
+// Processes a roomRequest of room creation, creates a room in the hub
+func (hub *Hub) createRoomRequest(session *SessionInfo, roomReq *RoomRequest) *Room {
+	if hub.ShuttingDown() {
+		session.SendError(ERR_SERVER_SHUTTING_DOWN, "")
+		return nil
+	}
+	if !hub.authorizeRoom(session, roomReq.AppName, true) {
+		return nil
+	}
+	//Public rooms without a secret can be joined by anyone from the room browser
+	if roomReq.RoomSecret == "" && !roomReq.Public {
+		session.SendError(ERR_SECRET_REQUIRED, "")
+		return nil
+	}
+	_r, _ := hub.RoomMap.Load(roomReq.RoomId)
+	if _r != nil {
+		session.SendError(ERR_ROOM_EXISTS, roomReq.RoomId)
+		return nil
+	}
+	max_room_peers := hub.maxRoomPeers(roomReq.AppName)
+	max_players := roomReq.MaxPlayers
+	if max_players == 0 {
+		max_players = min(DefaultRoomPeers, max_room_peers)
+	}
+	if max_players < 1 || max_players > max_room_peers {
+		session.SendError(ERR_INVALID_ROOM_SIZE, fmt.Sprintf("max=%d", max_room_peers))
+		return nil
+	}
+	slow_peer := hub.SlowPeerAction
+	if roomReq.SlowPeer != "" {
+		action, ok := slowPeerActionNames[roomReq.SlowPeer]
+		if !ok {
+			session.SendError(ERR_INVALID_ROOM_OPTION, "slow_peer="+roomReq.SlowPeer)
+			return nil
+		}
+		slow_peer = action
+	}
+	topology := TOPOLOGY_STAR
+	if roomReq.Topology != "" {
+		t, ok := roomTopologyNames[roomReq.Topology]
+		if !ok {
+			session.SendError(ERR_INVALID_ROOM_OPTION, "topology="+roomReq.Topology)
+			return nil
+		}
+		topology = t
+	}
+	new_room := &Room{
+		Secret:            roomReq.RoomSecret,
+		AppName:           roomReq.AppName,
+		Peers:             make([]*SessionInfo, max_players),
+		HostMigration:     roomReq.HostMigration,
+		Region:            roomReq.Region,
+		Public:            roomReq.Public,
+		Title:             roomReq.Title,
+		Metadata:          roomReq.Metadata,
+		PeerCount:         1,
+		Hub:               hub,
+		UserPacketChan:    make(chan UserPacket, hub.RoomQueueSize),
+		CmdChan:           make(chan RoomChanCmd, hub.RoomQueueSize),
+		CreationTimestamp: time.Now().UnixMilli(),
+		SlowPeer:          slow_peer,
+		Topology:          topology,
+		done:              make(chan struct{}),
+		AppStats:          hub.appStats(roomReq.AppName),
+		packetBucket:      newTokenBucket(hub.RateLimits.RoomPackets, hub.RateLimits.Burst),
+		byteBucket:        newTokenBucket(hub.RateLimits.RoomBytes, hub.RateLimits.Burst),
+	}
+	new_room.Peers[0] = session
+	new_room.AllowJoin.Store(roomReq.AllowJoin)
+	//Open before the room goroutine starts so the hub can join peers right away
+	new_room.Open.Store(true)
+	new_room.logger = new_room.newLogger()
+
+	//Must be called from hub corroutine, if it deadlocks is because
+	room_idx := slices.Index(hub.Rooms, nil)
+	if room_idx < 0 {
+		session.SendError(ERR_MAX_ROOMS, "")
+		return nil
+	}
+	//The client may have disconnected, or joined a room, while its request was queued
+	if !session.enterRoom(new_room) {
+		return nil
+	}
+	hub.Rooms[room_idx] = new_room
+	new_room.Id = room_idx
+	hub.getRandomRoomName(new_room)
+	session.IsHost = true
+	session.PeerId = 0
+	session.Name = session.displayName(roomReq.PlayerName)
+
+	hub.RoomMap.Store(new_room.Name, new_room)
+	atomic.AddInt64(&hub.Stats.RoomCreations, 1)
+
+	new_room.log().Info("room created", "max_players", max_players, "public", new_room.Public, "topology", topology, "unique_id", session.UniqueId)
+	go new_room.RoomGorroutine()
+	session.SendPacket(buildMsgPacket(MSG_ROOM_JOINING, 0, new_room.Name))
+	session.SendPacket(buildPlayerPacket(uint8(0), PLAYER_STATE_SELF, session.Name))
+	session.SendPacket(buildMsgPacket(MSG_ROOM_JOINED, 0, new_room.Name))
+	hub.issueResumeToken(session)
+
+	return new_room
+}
+
+// Hub's Packet handler. Must be called from the hub corroutine to conform to the
+// concurrency model
+func (hub *Hub) HandlePacket(sessionI *SessionInfo, msg []byte) {
+	if msg[0] == HUB_CMD_SC_CREATE_ROOM && sessionI.getRoom() == nil {
+		json_bytes := msg[1:]
+		data := RoomRequest{}
+		if err := json.Unmarshal(json_bytes, &data); err == nil {
+			sessionI.log().Debug("create room request", "request", data)
+			_ = hub.createRoomRequest(sessionI, &data)
+		} else {
+			sessionI.malformedPacket(err)
+		}
+	} else if msg[0] == HUB_CMD_SC_JOIN_ROOM && sessionI.getRoom() == nil {
+		json_bytes := msg[1:]
+		data := RoomRequest{}
+		if err := json.Unmarshal(json_bytes, &data); err == nil {
+			sessionI.log().Debug("join room request", "request", data)
+			_ = hub.joinRoomRequest(sessionI, &data)
+		} else {
+			sessionI.malformedPacket(err)
+		}
+	} else if msg[0] == HUB_CMD_SC_RESUME_SESSION && sessionI.getRoom() == nil {
+		data := RoomRequest{}
+		if err := json.Unmarshal(msg[1:], &data); err == nil {
+			_ = hub.resumeSessionRequest(sessionI, &data)
+		} else {
+			sessionI.malformedPacket(err)
+		}
+	} else if msg[0] == HUB_CMD_SC_LIST_ROOMS {
+		data := RoomListRequest{}
+		if err := json.Unmarshal(msg[1:], &data); err == nil {
+			page := hub.ListPublicRooms(&data)
+			page_json, _ := json.Marshal(page)
+			sessionI.SendPacket(buildMsgPacket(MSG_ROOM_LIST, 0, string(page_json)))
+		} else {
+			sessionI.malformedPacket(err)
+		}
+	} else if msg[0] == HUB_CMD_SC_QUICK_MATCH && sessionI.getRoom() == nil {
+		data := QuickMatchRequest{}
+		if err := json.Unmarshal(msg[1:], &data); err == nil {
+			hub.quickMatchRequest(sessionI, &data)
+		} else {
+			sessionI.malformedPacket(err)
+		}
+	} else if msg[0] == HUB_CMD_SC_QUICK_MATCH_CANCEL {
+		hub.cancelQuickMatch(sessionI)
+		sessionI.SendPacket(buildMsgPacket(MSG_QUICK_MATCH, MSG_QUEUE_CANCELED, ""))
+	}
+}
@@ -0,0 +1,468 @@
+package nexus
+
+import (
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Starts a hub behind a httptest server, both are stopped when the test ends
+func newTestHub(t *testing.T, opts ...Option) (*Hub, *httptest.Server) {
+	t.Helper()
+	opts = append([]Option{WithLogHandler(slog.NewTextHandler(io.Discard, nil))}, opts...)
+	hub := NewHub(opts...)
+	hub.Start()
+	srv := httptest.NewServer(hub.Handler())
+	t.Cleanup(func() {
+		srv.Close()
+		hub.Stop()
+	})
+	return hub, srv
+}
+
+type testClient struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+var testDialer = websocket.DefaultDialer
+
+// Returns the URL of the /ws endpoint of srv
+func testURL(srv *httptest.Server) string {
+	return "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws"
+}
+
+// Opens a websocket to the /ws endpoint of srv, query is appended to the URL
+func dialTest(t *testing.T, srv *httptest.Server, query string) *testClient {
+	t.Helper()
+	conn, _, err := testDialer.Dial(testURL(srv)+query, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &testClient{t: t, conn: conn}
+}
+
+func (c *testClient) send(msg []byte) {
+	c.t.Helper()
+	if err := c.conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *testClient) sendJSON(prefix []byte, v any) {
+	c.t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.send(append(prefix, b...))
+}
+
+// Sends a hello packet and returns the MSG_WELCOME reply
+func (c *testClient) hello(app_name string, capabilities ...string) HelloReply {
+	c.t.Helper()
+	c.sendJSON([]byte{PACKET_HELLO}, HelloRequest{ProtocolVersion: PROTOCOL_VERSION, AppName: app_name, Capabilities: capabilities})
+	reply := HelloReply{}
+	if err := json.Unmarshal(c.expect(2, MSG_WELCOME)[3:], &reply); err != nil {
+		c.t.Fatal(err)
+	}
+	return reply
+}
+
+// Waits until cond is true, what describes it in the failure
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(3 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// Returns the hub session of a client from its MSG_WELCOME reply
+func testSession(t *testing.T, hub *Hub, welcome HelloReply) *SessionInfo {
+	t.Helper()
+	value, _ := hub.SessionIds.Load(welcome.UniqueId)
+	s, _ := value.(*SessionInfo)
+	if s == nil {
+		t.Fatalf("session %q not found", welcome.UniqueId)
+	}
+	return s
+}
+
+func (c *testClient) read() []byte {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	_, msg, err := c.conn.ReadMessage()
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return msg
+}
+
+// Reads packets until one starts with kind and cmd, other packets are skipped. An
+// unexpected MSG_ERROR fails the test.
+func (c *testClient) expect(kind byte, cmd byte) []byte {
+	c.t.Helper()
+	for i := 0; i < 1024; i++ {
+		msg := c.read()
+		if len(msg) > 1 && msg[0] == kind && msg[1] == cmd {
+			return msg
+		}
+		if len(msg) > 2 && msg[0] == 2 && msg[1] == MSG_ERROR {
+			c.t.Fatalf("error %d %q while waiting for packet %d %d", msg[2], msg[3:], kind, cmd)
+		}
+	}
+	c.t.Fatalf("packet %d %d not received", kind, cmd)
+	return nil
+}
+
+// Waits for a MSG_ERROR packet and checks its code
+func (c *testClient) expectError(code ErrorCode) {
+	c.t.Helper()
+	if msg := c.expect(2, MSG_ERROR); ErrorCode(msg[2]) != code {
+		c.t.Fatalf("error %d %q, want %d", msg[2], msg[3:], code)
+	}
+}
+
+// Waits for a player packet with state and returns the peer id
+func (c *testClient) expectPlayer(state byte) byte {
+	c.t.Helper()
+	for i := 0; i < 32; i++ {
+		msg := c.expect(1, 3)
+		if msg[3] == state {
+			return msg[2]
+		}
+	}
+	c.t.Fatalf("player state %d not received", state)
+	return 0
+}
+
+// Waits until the server closes the connection
+func (c *testClient) expectClose() {
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(3 * time.Second))
+	for {
+		if _, _, err := c.conn.ReadMessage(); err != nil {
+			if ne, ok := err.(interface{ Timeout() bool }); ok && ne.Timeout() {
+				c.t.Fatal("connection not closed")
+			}
+			return
+		}
+	}
+}
+
+// Creates a room and returns its name
+func (c *testClient) createRoom(req RoomRequest) string {
+	c.t.Helper()
+	c.sendJSON([]byte{PACKET_HUB, HUB_CMD_SC_CREATE_ROOM}, req)
+	name := string(c.expect(2, MSG_ROOM_JOINING)[3:])
+	c.expect(2, MSG_ROOM_JOINED)
+	return name
+}
+
+func (c *testClient) joinRoom(req RoomRequest) {
+	c.t.Helper()
+	c.sendJSON([]byte{PACKET_HUB, HUB_CMD_SC_JOIN_ROOM}, req)
+	c.expect(2, MSG_ROOM_JOINED)
+}
+
+func TestRoomLifecycle(t *testing.T) {
+	_, srv := newTestHub(t)
+
+	host := dialTest(t, srv, "")
+	host.hello("game")
+	room := host.createRoom(RoomRequest{AppName: "game", RoomSecret: "pwd", PlayerName: "host", AllowJoin: true, MaxPlayers: 3})
+
+	peer := dialTest(t, srv, "")
+	peer.hello("game")
+	peer.sendJSON([]byte{PACKET_HUB, HUB_CMD_SC_JOIN_ROOM}, RoomRequest{RoomId: room, AppName: "game", RoomSecret: "bad"})
+	peer.expectError(ERR_INVALID_PASSWORD)
+	peer.joinRoom(RoomRequest{RoomId: room, AppName: "game", RoomSecret: "pwd", PlayerName: "peer"})
+	peer_id := host.expectPlayer(PLAYER_STATE_JOINED)
+	if peer_id != 1 {
+		t.Fatalf("peer id %d, want 1", peer_id)
+	}
+
+	peer.send([]byte{PACKET_ROOM, ROOM_CMD_PEER_PACKET_SEND, 0, 0, 255, 'h', 'i'})
+	if msg := host.expect(1, 0); msg[2] != peer_id || string(msg[4:]) != "hi" {
+		t.Fatalf("host got %v", msg)
+	}
+	host.send([]byte{PACKET_ROOM, ROOM_CMD_PEER_PACKET_SEND, 0, peer_id, 255, 'o', 'k'})
+	if msg := peer.expect(1, 0); msg[2] != 0 || string(msg[4:]) != "ok" {
+		t.Fatalf("peer got %v", msg)
+	}
+
+	peer.send([]byte{PACKET_ROOM, ROOM_CMD_LEAVE_ROOM})
+	peer.expectError(ERR_ROOM_LEFT)
+	if left := host.expectPlayer(PLAYER_STATE_LEFT); left != peer_id {
+		t.Fatalf("left peer %d, want %d", left, peer_id)
+	}
+	peer.expectClose()
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	_, srv := newTestHub(t, WithHandshakeTimeout(100*time.Millisecond))
+	dialTest(t, srv, "").expectClose()
+}
+
+func TestHelloFeatures(t *testing.T) {
+	_, srv := newTestHub(t)
+	all := []string{"error_codes", "host_migration", "resume", "room_browser", "quick_match", "multicast", "topology", "latency"}
+	tests := []struct {
+		name         string
+		capabilities []string
+		features     []string
+	}{
+		{"none", nil, []string{}},
+		{"all", all, all},
+		{"unknown and duplicate", []string{"latency", "teleport", "multicast", "latency"}, []string{"latency", "multicast"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := dialTest(t, srv, "").hello("game", tt.capabilities...)
+			if !slices.Equal(reply.Features, tt.features) {
+				t.Fatalf("features %v, want %v", reply.Features, tt.features)
+			}
+		})
+	}
+}
+
+// A peer that leaves and closes its socket right away must not crash its room
+func TestLeaveThenDisconnect(t *testing.T) {
+	hub, srv := newTestHub(t)
+	host := dialTest(t, srv, "")
+	host.hello("game")
+	room := host.createRoom(RoomRequest{AppName: "game", RoomSecret: "pwd", PlayerName: "host", AllowJoin: true, MaxPlayers: 8})
+	for i := 0; i < 5; i++ {
+		peer := dialTest(t, srv, "")
+		peer.hello("game")
+		peer.joinRoom(RoomRequest{RoomId: room, AppName: "game", RoomSecret: "pwd"})
+		peer.send([]byte{PACKET_ROOM, ROOM_CMD_LEAVE_ROOM})
+		peer.conn.Close()
+		host.expectPlayer(PLAYER_STATE_LEFT)
+	}
+	host.send([]byte{PACKET_ECHO, 'x'})
+	host.expect(PACKET_ECHO, 'x')
+	if n := atomic.LoadInt64(&hub.RoomCount); n != 1 {
+		t.Fatalf("%d rooms, want 1", n)
+	}
+}
+
+// Names sent by clients must be escaped in the /list page, it holds the admin token
+func TestListEscapesClientData(t *testing.T) {
+	_, srv := newTestHub(t)
+	app_name := `game"');alert(1)//`
+	host := dialTest(t, srv, "")
+	host.hello(app_name)
+	host.createRoom(RoomRequest{AppName: app_name, RoomSecret: "pwd", PlayerName: "<script>alert(1)</script>"})
+
+	res, err := http.Get(srv.URL + "/list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(res.Body)
+	for _, raw := range []string{"<script>alert(1)</script>", app_name} {
+		if strings.Contains(string(body), raw) {
+			t.Errorf("/list contains unescaped %q", raw)
+		}
+	}
+	if !strings.Contains(string(body), "&lt;script&gt;alert(1)&lt;/script&gt;") {
+		t.Error("/list doesn't contain the escaped player name")
+	}
+}
+
+// Unknown room options are rejected instead of falling back to a default
+func TestCreateRoomOptions(t *testing.T) {
+	_, srv := newTestHub(t)
+	c := dialTest(t, srv, "")
+	c.hello("game")
+	tests := []struct {
+		req  RoomRequest
+		code ErrorCode
+	}{
+		{RoomRequest{MaxPlayers: MaxRoomPeers + 1}, ERR_INVALID_ROOM_SIZE},
+		{RoomRequest{SlowPeer: "dropp"}, ERR_INVALID_ROOM_OPTION},
+		{RoomRequest{Topology: "meshh"}, ERR_INVALID_ROOM_OPTION},
+	}
+	for _, tt := range tests {
+		tt.req.AppName = "game"
+		tt.req.RoomSecret = "pwd"
+		c.sendJSON([]byte{PACKET_HUB, HUB_CMD_SC_CREATE_ROOM}, tt.req)
+		c.expectError(tt.code)
+	}
+	c.createRoom(RoomRequest{AppName: "game", RoomSecret: "pwd", SlowPeer: "kick", Topology: "mesh"})
+}
+
+// Clients closing their socket right after a join must not crash the room that handles it
+func TestJoinThenClose(t *testing.T) {
+	hub, srv := newTestHub(t)
+	host := dialTest(t, srv, "")
+	host.hello("game")
+	room := host.createRoom(RoomRequest{AppName: "game", RoomSecret: "pwd", AllowJoin: true, MaxPlayers: MaxRoomPeers})
+	for i := 0; i < 200; i++ {
+		peer := dialTest(t, srv, "")
+		peer.hello("game")
+		peer.sendJSON([]byte{PACKET_HUB, HUB_CMD_SC_JOIN_ROOM}, RoomRequest{RoomId: room, AppName: "game", RoomSecret: "pwd"})
+		peer.conn.Close()
+	}
+	host.send([]byte{PACKET_ECHO, 'x'})
+	host.expect(PACKET_ECHO, 'x')
+	value, _ := hub.RoomMap.Load(room)
+	peer_count := &value.(*Room).PeerCount
+	waitFor(t, "the peers to leave", func() bool { return atomic.LoadInt64(peer_count) == 1 })
+}
+
+// Public rooms are listed in the browser and only need a secret if they were created with one
+func TestPublicRoomSecret(t *testing.T) {
+	_, srv := newTestHub(t)
+	c := dialTest(t, srv, "")
+	c.hello("game")
+	c.sendJSON([]byte{PACKET_HUB, HUB_CMD_SC_CREATE_ROOM}, RoomRequest{AppName: "game"})
+	c.expectError(ERR_SECRET_REQUIRED)
+
+	open := c.createRoom(RoomRequest{AppName: "game", Public: true, AllowJoin: true})
+	locked_host := dialTest(t, srv, "")
+	locked_host.hello("game")
+	locked := locked_host.createRoom(RoomRequest{AppName: "game", RoomSecret: "pwd", Public: true, AllowJoin: true})
+
+	res, err := http.Get(srv.URL + "/rooms?app_name=game")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	page := RoomListPage{}
+	if err := json.NewDecoder(res.Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	listed := map[string]bool{}
+	for _, r := range page.Rooms {
+		listed[r.RoomId] = r.Locked
+	}
+	if is_locked, ok := listed[open]; !ok || is_locked {
+		t.Errorf("open room listed %v, locked %v", ok, is_locked)
+	}
+	if is_locked, ok := listed[locked]; !ok || !is_locked {
+		t.Errorf("locked room listed %v, locked %v", ok, is_locked)
+	}
+
+	peer := dialTest(t, srv, "")
+	peer.hello("game")
+	peer.sendJSON([]byte{PACKET_HUB, HUB_CMD_SC_JOIN_ROOM}, RoomRequest{RoomId: locked, AppName: "game"})
+	peer.expectError(ERR_INVALID_PASSWORD)
+	peer.joinRoom(RoomRequest{RoomId: locked, AppName: "game", RoomSecret: "pwd"})
+
+	other := dialTest(t, srv, "")
+	other.hello("game")
+	other.joinRoom(RoomRequest{RoomId: open, AppName: "game"})
+}
+
+// Joins a room of a hub with a resume grace period and returns the resume token
+func (c *testClient) joinResumable(room string) string {
+	c.t.Helper()
+	c.joinRoom(RoomRequest{RoomId: room, AppName: "game", RoomSecret: "pwd"})
+	return string(c.expect(2, MSG_RESUME_TOKEN)[3:])
+}
+
+// Opens a new connection and resumes the session of token
+func resumeTest(t *testing.T, srv *httptest.Server, token string) *testClient {
+	t.Helper()
+	c := dialTest(t, srv, "")
+	c.hello("game")
+	c.sendJSON([]byte{PACKET_HUB, HUB_CMD_SC_RESUME_SESSION}, RoomRequest{ResumeToken: token})
+	return c
+}
+
+func TestResumeSession(t *testing.T) {
+	_, srv := newTestHub(t, WithResumeGracePeriod(3*time.Second))
+	host := dialTest(t, srv, "")
+	host.hello("game")
+	room := host.createRoom(RoomRequest{AppName: "game", RoomSecret: "pwd", AllowJoin: true})
+	peer := dialTest(t, srv, "")
+	peer.hello("game")
+	token := peer.joinResumable(room)
+	peer_id := host.expectPlayer(PLAYER_STATE_JOINED)
+
+	peer.conn.Close()
+	if id := host.expectPlayer(PLAYER_STATE_RECONNECTING); id != peer_id {
+		t.Fatalf("reconnecting peer %d, want %d", id, peer_id)
+	}
+	resumed := resumeTest(t, srv, token)
+	resumed.expect(2, MSG_ROOM_JOINED)
+	if id := host.expectPlayer(PLAYER_STATE_RECONNECTED); id != peer_id {
+		t.Fatalf("reconnected peer %d, want %d", id, peer_id)
+	}
+	host.send([]byte{PACKET_ROOM, ROOM_CMD_PEER_PACKET_SEND, 0, peer_id, 255, 'o', 'k'})
+	if msg := resumed.expect(1, 0); string(msg[4:]) != "ok" {
+		t.Fatalf("resumed peer got %v", msg)
+	}
+}
+
+// Peers that don't resume in the grace period leave the room and their token expires
+func TestResumeGraceExpiry(t *testing.T) {
+	_, srv := newTestHub(t, WithResumeGracePeriod(100*time.Millisecond))
+	host := dialTest(t, srv, "")
+	host.hello("game")
+	room := host.createRoom(RoomRequest{AppName: "game", RoomSecret: "pwd", AllowJoin: true})
+	peer := dialTest(t, srv, "")
+	peer.hello("game")
+	token := peer.joinResumable(room)
+	peer_id := host.expectPlayer(PLAYER_STATE_JOINED)
+
+	peer.conn.Close()
+	host.expectPlayer(PLAYER_STATE_RECONNECTING)
+	if id := host.expectPlayer(PLAYER_STATE_LEFT); id != peer_id {
+		t.Fatalf("left peer %d, want %d", id, peer_id)
+	}
+	resumeTest(t, srv, token).expectError(ERR_SESSION_EXPIRED)
+}
+
+// Peers disconnected by the server leave the room instead of waiting for a resume
+func TestKickedPeerCantResume(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		kick func(c *testClient)
+	}{
+		{"malformed packet", nil, func(c *testClient) {
+			c.send([]byte{PACKET_ROOM, 99})
+		}},
+		{"rate limit", []Option{WithRateLimits(RateLimits{SessionPackets: 4, Burst: 1})}, func(c *testClient) {
+			for i := 0; i < 10; i++ {
+				c.send([]byte{PACKET_ECHO})
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, srv := newTestHub(t, append(tt.opts, WithResumeGracePeriod(3*time.Second))...)
+			host := dialTest(t, srv, "")
+			host.hello("game")
+			room := host.createRoom(RoomRequest{AppName: "game", RoomSecret: "pwd", AllowJoin: true})
+			peer := dialTest(t, srv, "")
+			peer.hello("game")
+			token := peer.joinResumable(room)
+			host.expectPlayer(PLAYER_STATE_JOINED)
+
+			tt.kick(peer)
+			peer.expectClose()
+			if msg := host.expect(1, 3); msg[3] != PLAYER_STATE_LEFT {
+				t.Fatalf("peer state %d, want PLAYER_STATE_LEFT", msg[3])
+			}
+			resumeTest(t, srv, token).expectError(ERR_SESSION_EXPIRED)
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package nexus
+
+import (
+	"encoding/json"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// How often sessions are pinged and room hosts get MSG_PEER_LATENCY
+	DefaultPingInterval = 2 * time.Second
+	// Time a ping frame may wait for the websocket connection
+	PingWriteTimeout = time.Second
+)
+
+// Round trip state of the websocket pings sent by the server. Pings are control frames, so
+// clients answer them without protocol support. melody doesn't pass the pong payload, so only
+// one ping is outstanding at a time.
+type pingState struct {
+	mut    sync.Mutex
+	sentAt time.Time
+	last   time.Duration
+}
+
+// Latency of a peer as sent to the room host in MSG_PEER_LATENCY
+type PeerLatency struct {
+	PeerId   int     `json:"peer_id"`
+	RttMS    float64 `json:"rtt_ms"`
+	JitterMS float64 `json:"jitter_ms"`
+}
+
+// Pings every session each PingInterval until the hub stops
+func (hub *Hub) pingLoop() {
+	ticker := time.NewTicker(hub.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-hub.done:
+			return
+		case <-ticker.C:
+			hub.SessionMap.Range(func(k any, v any) bool {
+				go v.(*SessionInfo).sendPing()
+				return true
+			})
+		}
+	}
+}
+
+// Writes a ping frame to the session, a ping left unanswered is replaced
+func (s *SessionInfo) sendPing() {
+	session := s.conn()
+	if session == nil || session.IsClosed() {
+		return
+	}
+	s.ping.mut.Lock()
+	s.ping.sentAt = time.Now()
+	s.ping.mut.Unlock()
+	atomic.AddInt64(&s.Stats.Pings, 1)
+	err := session.WebsocketConnection().WriteControl(websocket.PingMessage, nil, time.Now().Add(PingWriteTimeout))
+	if err != nil {
+		s.log().Debug("ping failed", "error", err)
+	}
+}
+
+// Updates the smoothed RTT and the jitter with the round trip of the outstanding ping, the
+// same way as TCP's SRTT and RFC 3550's interarrival jitter
+func (s *SessionInfo) pongReceived() {
+	s.ping.mut.Lock()
+	defer s.ping.mut.Unlock()
+	if s.ping.sentAt.IsZero() {
+		return
+	}
+	sample := time.Since(s.ping.sentAt)
+	s.ping.sentAt = time.Time{}
+
+	rtt := time.Duration(atomic.LoadInt64(&s.Stats.RttUS)) * time.Microsecond
+	jitter := time.Duration(atomic.LoadInt64(&s.Stats.JitterUS)) * time.Microsecond
+	if atomic.AddInt64(&s.Stats.Pongs, 1) == 1 {
+		rtt = sample
+	} else {
+		rtt += (sample - rtt) / 8
+		diff := sample - s.ping.last
+		if diff < 0 {
+			diff = -diff
+		}
+		jitter += (diff - jitter) / 16
+	}
+	s.ping.last = sample
+	atomic.StoreInt64(&s.Stats.RttUS, rtt.Microseconds())
+	atomic.StoreInt64(&s.Stats.JitterUS, jitter.Microseconds())
+}
+
+// Returns the smoothed RTT and jitter of the session in milliseconds, rounded to 0.1
+func (s *SessionInfo) Latency() (rtt_ms float64, jitter_ms float64) {
+	rtt_ms = math.Round(float64(atomic.LoadInt64(&s.Stats.RttUS))/100) / 10
+	jitter_ms = math.Round(float64(atomic.LoadInt64(&s.Stats.JitterUS))/100) / 10
+	return
+}
+
+// Sends the latency of the peers already measured to the room host
+func (room *Room) sendLatencyReport() {
+	host := room.Peers[room.HostId]
+	if host == nil {
+		return
+	}
+	report := make([]PeerLatency, 0, len(room.Peers))
+	for idx, p := range room.Peers {
+		if p == nil || p.Reconnecting || atomic.LoadInt64(&p.Stats.Pongs) == 0 {
+			continue
+		}
+		rtt_ms, jitter_ms := p.Latency()
+		report = append(report, PeerLatency{PeerId: idx, RttMS: rtt_ms, JitterMS: jitter_ms})
+	}
+	if len(report) == 0 {
+		return
+	}
+	b, _ := json.Marshal(report)
+	host.SendPacket(buildMsgPacket(MSG_PEER_LATENCY, uint8(len(report)), string(b)))
+}
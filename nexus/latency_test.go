@@ -0,0 +1,102 @@
+package nexus
+
+import (
+	"encoding/json"
+	"math"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Answers a ping sent rtt ago
+func pongAfter(s *SessionInfo, rtt time.Duration) {
+	s.ping.mut.Lock()
+	s.ping.sentAt = time.Now().Add(-rtt)
+	s.ping.mut.Unlock()
+	s.pongReceived()
+}
+
+func TestPongSmoothing(t *testing.T) {
+	s := &SessionInfo{}
+	s.pongReceived()
+	if n := atomic.LoadInt64(&s.Stats.Pongs); n != 0 {
+		t.Fatalf("pong without a ping counted, %d pongs", n)
+	}
+
+	tests := []struct {
+		sample    time.Duration
+		rtt_ms    float64
+		jitter_ms float64
+	}{
+		// The first sample sets the RTT, later ones move it 1/8 and the jitter 1/16 of the way
+		{100 * time.Millisecond, 100, 0},
+		{180 * time.Millisecond, 110, 5},
+		{100 * time.Millisecond, 108.75, 9.6875},
+		{100 * time.Millisecond, 107.65625, 9.08203125},
+	}
+	for i, tt := range tests {
+		pongAfter(s, tt.sample)
+		rtt_ms := float64(atomic.LoadInt64(&s.Stats.RttUS)) / 1000
+		jitter_ms := float64(atomic.LoadInt64(&s.Stats.JitterUS)) / 1000
+		// Allow for the time pongAfter takes
+		if math.Abs(rtt_ms-tt.rtt_ms) > 1 || math.Abs(jitter_ms-tt.jitter_ms) > 1 {
+			t.Fatalf("sample %d: rtt %gms jitter %gms, want %gms and %gms", i, rtt_ms, jitter_ms, tt.rtt_ms, tt.jitter_ms)
+		}
+	}
+	if n := atomic.LoadInt64(&s.Stats.Pongs); n != int64(len(tests)) {
+		t.Fatalf("%d pongs, want %d", n, len(tests))
+	}
+
+	// A second pong for the same ping is ignored
+	rtt := atomic.LoadInt64(&s.Stats.RttUS)
+	s.pongReceived()
+	if atomic.LoadInt64(&s.Stats.RttUS) != rtt || atomic.LoadInt64(&s.Stats.Pongs) != int64(len(tests)) {
+		t.Fatal("duplicate pong changed the stats")
+	}
+
+	atomic.StoreInt64(&s.Stats.RttUS, 12345)
+	atomic.StoreInt64(&s.Stats.JitterUS, 670)
+	if rtt_ms, jitter_ms := s.Latency(); rtt_ms != 12.3 || jitter_ms != 0.7 {
+		t.Fatalf("latency %g %g, want 12.3 0.7", rtt_ms, jitter_ms)
+	}
+}
+
+func TestLatencyReport(t *testing.T) {
+	_, srv := newTestHub(t, WithPingInterval(20*time.Millisecond))
+	host := dialTest(t, srv, "")
+	host.hello("game")
+	room := host.createRoom(RoomRequest{AppName: "game", RoomSecret: "pwd", AllowJoin: true})
+	peer := dialTest(t, srv, "")
+	peer.hello("game")
+	peer.joinRoom(RoomRequest{RoomId: room, AppName: "game", RoomSecret: "pwd"})
+	host.expectPlayer(PLAYER_STATE_JOINED)
+	// gorilla answers pings while reading
+	go func() {
+		for {
+			if _, _, err := peer.conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < 100; i++ {
+		msg := host.expect(2, MSG_PEER_LATENCY)
+		report := []PeerLatency{}
+		if err := json.Unmarshal(msg[3:], &report); err != nil {
+			t.Fatal(err)
+		}
+		if int(msg[2]) != len(report) {
+			t.Fatalf("report of %d peers counts %d", len(report), msg[2])
+		}
+		if len(report) < 2 {
+			continue
+		}
+		for idx, p := range report {
+			if p.PeerId != idx || p.RttMS < 0 || p.RttMS > 1000 || p.JitterMS < 0 {
+				t.Fatalf("report %+v", report)
+			}
+		}
+		return
+	}
+	t.Fatal("no report with both peers")
+}
@@ -0,0 +1,159 @@
+package nexus
+
+import (
+	"context"
+	"log/slog"
+)
+
+// Subsystems with their own log level
+const (
+	LOG_HUB     = "hub"     // Hub goroutine, client registration and shutdown
+	LOG_ROOM    = "room"    // Room lifecycle and peer packets
+	LOG_SESSION = "session" // Client packets, handshake, rate limits and validation
+	LOG_ADMIN   = "admin"   // Admin API actions
+	LOG_TLS     = "tls"     // Certificate reloads
+)
+
+var LogSubsystems = []string{LOG_HUB, LOG_ROOM, LOG_SESSION, LOG_ADMIN, LOG_TLS}
+
+// Filters the records of a subsystem by its own level, the wrapped handler's level is
+// not checked so subsystems can be more verbose than the rest
+type levelHandler struct {
+	level   slog.Leveler
+	handler slog.Handler
+}
+
+func (h *levelHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *levelHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.handler.Handle(ctx, r)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{h.level, h.handler.WithAttrs(attrs)}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{h.level, h.handler.WithGroup(name)}
+}
+
+// Adds attributes computed when a record is handled. Session and room loggers are built once
+// with it, their fields are only read for records that pass the level check and always
+// have their current values.
+type lazyAttrsHandler struct {
+	attrs   func() []slog.Attr
+	handler slog.Handler
+}
+
+func (h *lazyAttrsHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return h.handler.Enabled(ctx, level)
+}
+
+func (h *lazyAttrsHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.handler.WithAttrs(h.attrs()).Handle(ctx, r)
+}
+
+func (h *lazyAttrsHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &lazyAttrsHandler{h.attrs, h.handler.WithAttrs(attrs)}
+}
+
+func (h *lazyAttrsHandler) WithGroup(name string) slog.Handler {
+	return &lazyAttrsHandler{h.attrs, h.handler.WithGroup(name)}
+}
+
+// Builds the logger of each subsystem, called by NewHub once the options are applied
+func (hub *Hub) setupLoggers() {
+	handler := hub.LogHandler
+	if handler == nil {
+		handler = slog.Default().Handler()
+	}
+	hub.loggers = make(map[string]*slog.Logger, len(LogSubsystems))
+	for _, name := range LogSubsystems {
+		level, ok := hub.LogLevels[name]
+		if !ok {
+			level = hub.LogLevel
+		}
+		hub.loggers[name] = slog.New(&levelHandler{level, handler}).With("subsystem", name)
+	}
+}
+
+// Logger returns the logger of a subsystem, for components built next to the hub such as
+// CertReloader
+func (hub *Hub) Logger(subsystem string) *slog.Logger {
+	return hub.logger(subsystem)
+}
+
+// Returns the logger of a subsystem
+func (hub *Hub) logger(subsystem string) *slog.Logger {
+	if hub == nil || hub.loggers == nil {
+		return slog.Default()
+	}
+	return hub.loggers[subsystem]
+}
+
+// Returns the session logger with the fields identifying the session
+func (s *SessionInfo) log() *slog.Logger {
+	if s.logger == nil {
+		//Sessions not registered by setupMelody
+		return s.newLogger()
+	}
+	return s.logger
+}
+
+func (s *SessionInfo) newLogger() *slog.Logger {
+	return slog.New(&lazyAttrsHandler{s.logAttrs, s.Hub.logger(LOG_SESSION).Handler()})
+}
+
+func (s *SessionInfo) logAttrs() []slog.Attr {
+	attrs := []slog.Attr{slog.String("unique_id", s.UniqueId), slog.String("remote_addr", s.RemoteAddr())}
+	if s.AppName != "" {
+		attrs = append(attrs, slog.String("app", s.AppName))
+	}
+	return attrs
+}
+
+// Returns the room logger with the fields identifying the room
+func (room *Room) log() *slog.Logger {
+	if room.logger == nil {
+		//Rooms not created by createRoomRequest
+		return room.newLogger()
+	}
+	return room.logger
+}
+
+func (room *Room) newLogger() *slog.Logger {
+	return slog.New(&lazyAttrsHandler{room.logAttrs, room.Hub.logger(LOG_ROOM).Handler()})
+}
+
+func (room *Room) logAttrs() []slog.Attr {
+	return []slog.Attr{slog.String("room", room.Name), slog.String("app", room.AppName)}
+}
+
+// Returns the room logger with the fields identifying a peer of the room
+func (room *Room) peerLog(s *SessionInfo) *slog.Logger {
+	return slog.New(&lazyAttrsHandler{func() []slog.Attr {
+		return append(room.logAttrs(),
+			slog.Int("peer_id", s.PeerId), slog.String("unique_id", s.UniqueId), slog.String("remote_addr", s.RemoteAddr()))
+	}, room.Hub.logger(LOG_ROOM).Handler()})
+}
+
+// Room requests are logged without their secrets
+func (r RoomRequest) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.String("room_id", r.RoomId),
+		slog.String("app_name", r.AppName),
+		slog.String("player_name", r.PlayerName),
+		slog.Int("max_players", r.MaxPlayers),
+		slog.Bool("public", r.Public),
+		slog.String("region", r.Region),
+	}
+	if r.RoomSecret != "" {
+		attrs = append(attrs, slog.String("room_pwd", "REDACTED"))
+	}
+	if r.ResumeToken != "" {
+		attrs = append(attrs, slog.String("resume_token", "REDACTED"))
+	}
+	return slog.GroupValue(attrs...)
+}
@@ -0,0 +1,29 @@
+package nexus
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+// Logger fields are only computed for enabled records and hold their current values
+func TestLazyAttrsHandler(t *testing.T) {
+	var buf bytes.Buffer
+	calls := 0
+	name := "a"
+	log := slog.New(&lazyAttrsHandler{func() []slog.Attr {
+		calls++
+		return []slog.Attr{slog.String("room", name)}
+	}, slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo})})
+
+	log.Debug("dropped")
+	if calls != 0 || buf.Len() != 0 {
+		t.Fatalf("disabled record computed %d times: %q", calls, buf.String())
+	}
+	name = "b"
+	log.With("peer_id", 1).Info("joined")
+	if calls != 1 || !strings.Contains(buf.String(), "peer_id=1 room=b") {
+		t.Fatalf("calls %d, output %q", calls, buf.String())
+	}
+}
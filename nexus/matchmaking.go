@@ -0,0 +1,191 @@
+package nexus
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Default time a client waits in a quick-match queue before the request fails
+const DefaultQuickMatchTimeout = 30 * time.Second
+
+// QuickMatchRequest is the payload of HUB_CMD_SC_QUICK_MATCH. RoomSize is the capacity of
+// the room and MinPlayers the number of queued players needed to create a new one.
+type QuickMatchRequest struct {
+	AppName    string `json:"app_name"`
+	PlayerName string `json:"player_name"`
+	RoomSize   int    `json:"room_size"`
+	MinPlayers int    `json:"min_players"`
+	Region     string `json:"region"`
+}
+
+// A client waiting in a quick-match queue
+type matchTicket struct {
+	Session     *SessionInfo
+	Req         *QuickMatchRequest
+	TimestampMS uint64
+}
+
+// Tickets are grouped by every parameter that must match between players
+func (req *QuickMatchRequest) queueKey() string {
+	return req.AppName + "|" + req.Region + "|" + strconv.Itoa(req.RoomSize) + "|" + strconv.Itoa(req.MinPlayers)
+}
+
+// Processes a quick-match request. The client joins an existing public room if one accepts
+// players, otherwise it is queued until MinPlayers clients are waiting and a room is created
+// with the first one as host. Must be called from the hub goroutine.
+func (hub *Hub) quickMatchRequest(session *SessionInfo, req *QuickMatchRequest) {
+	if session.getRoom() != nil {
+		return
+	}
+	if hub.ShuttingDown() {
+		session.SendError(ERR_SERVER_SHUTTING_DOWN, "")
+		return
+	}
+	max_room_peers := hub.maxRoomPeers(req.AppName)
+	if req.RoomSize == 0 {
+		req.RoomSize = min(DefaultRoomPeers, max_room_peers)
+	}
+	if req.RoomSize < 2 || req.RoomSize > max_room_peers {
+		session.SendError(ERR_INVALID_ROOM_SIZE, fmt.Sprintf("max=%d", max_room_peers))
+		return
+	}
+	if req.MinPlayers <= 0 || req.MinPlayers > req.RoomSize {
+		req.MinPlayers = min(2, req.RoomSize)
+	}
+	hub.cancelQuickMatch(session)
+
+	if room := hub.findQuickMatchRoom(req); room != nil {
+		hub.joinRoomRequest(session, &RoomRequest{
+			RoomId:     room.Name,
+			AppName:    req.AppName,
+			PlayerName: req.PlayerName,
+		})
+		return
+	}
+
+	key := req.queueKey()
+	queue := append(hub.pruneMatchQueue(hub.MatchQueues[key]), &matchTicket{
+		Session:     session,
+		Req:         req,
+		TimestampMS: GetUnixTimestampMS(),
+	})
+	if len(queue) < req.MinPlayers {
+		hub.MatchQueues[key] = queue
+		session.SendPacket(buildMsgPacket(MSG_QUICK_MATCH, MSG_QUEUE_WAITING, strconv.Itoa(len(queue))))
+		return
+	}
+
+	count := min(len(queue), req.RoomSize)
+	matched := queue[:count]
+	if len(queue) > count {
+		hub.MatchQueues[key] = queue[count:]
+	} else {
+		delete(hub.MatchQueues, key)
+	}
+	hub.createQuickMatchRoom(matched)
+}
+
+// Returns the public room with more players that accepts the request, nil if none. Rooms
+// with a secret are left out.
+func (hub *Hub) findQuickMatchRoom(req *QuickMatchRequest) *Room {
+	var best *Room
+	var best_count int64
+	hub.RoomMap.Range(func(key any, value any) bool {
+		room := value.(*Room)
+		if !room.Open.Load() || !room.Public || room.Secret != "" || !room.AllowJoin.Load() || room.AppName != req.AppName ||
+			room.Region != req.Region || len(room.Peers) != req.RoomSize {
+			return true
+		}
+		count := atomic.LoadInt64(&room.PeerCount)
+		if int(count) < len(room.Peers) && (best == nil || count > best_count) {
+			best = room
+			best_count = count
+		}
+		return true
+	})
+	return best
+}
+
+// Creates a public room for a group of matched tickets, the first ticket is the host
+func (hub *Hub) createQuickMatchRoom(tickets []*matchTicket) {
+	//Under a join-only auth policy the host must be a verified session
+	if !tickets[0].Session.Verified && hub.authPolicy(tickets[0].Req.AppName) == AUTH_POLICY_JOIN_ONLY {
+		for idx, t := range tickets {
+			if t.Session.Verified {
+				tickets[0], tickets[idx] = tickets[idx], tickets[0]
+				break
+			}
+		}
+	}
+	host := tickets[0]
+	room := hub.createRoomRequest(host.Session, &RoomRequest{
+		AppName:    host.Req.AppName,
+		PlayerName: host.Req.PlayerName,
+		MaxPlayers: host.Req.RoomSize,
+		Public:     true,
+		AllowJoin:  true,
+		Region:     host.Req.Region,
+	})
+	if room == nil {
+		for _, t := range tickets[1:] {
+			t.Session.SendError(ERR_NO_MATCH_FOUND, "")
+		}
+		return
+	}
+	for _, t := range tickets[1:] {
+		hub.joinRoomRequest(t.Session, &RoomRequest{
+			RoomId:     room.Name,
+			AppName:    t.Req.AppName,
+			PlayerName: t.Req.PlayerName,
+		})
+	}
+}
+
+// Removes tickets of clients that disconnected or joined a room by other means
+func (hub *Hub) pruneMatchQueue(queue []*matchTicket) []*matchTicket {
+	alive := queue[:0]
+	for _, t := range queue {
+		if t.Session.conn() != nil && t.Session.getRoom() == nil {
+			alive = append(alive, t)
+		}
+	}
+	return alive
+}
+
+// Removes a client from every quick-match queue
+func (hub *Hub) cancelQuickMatch(session *SessionInfo) {
+	for key, queue := range hub.MatchQueues {
+		for idx, t := range queue {
+			if t.Session == session {
+				hub.MatchQueues[key] = append(queue[:idx], queue[idx+1:]...)
+				break
+			}
+		}
+		if len(hub.MatchQueues[key]) == 0 {
+			delete(hub.MatchQueues, key)
+		}
+	}
+}
+
+// Fails the quick-match requests that waited longer than the hub's timeout
+func (hub *Hub) expireMatchTickets(current_time uint64) {
+	timeout_ms := uint64(hub.QuickMatchTimeout.Milliseconds())
+	for key, queue := range hub.MatchQueues {
+		queue = hub.pruneMatchQueue(queue)
+		waiting := queue[:0]
+		for _, t := range queue {
+			if t.TimestampMS+timeout_ms < current_time {
+				t.Session.SendError(ERR_NO_MATCH_FOUND, "")
+			} else {
+				waiting = append(waiting, t)
+			}
+		}
+		if len(waiting) == 0 {
+			delete(hub.MatchQueues, key)
+		} else {
+			hub.MatchQueues[key] = waiting
+		}
+	}
+}
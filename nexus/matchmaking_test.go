@@ -0,0 +1,51 @@
+package nexus
+
+import "testing"
+
+// Every matched player must end up in the room created for the match
+func TestQuickMatchCreatesRoom(t *testing.T) {
+	_, srv := newTestHub(t)
+	clients := make([]*testClient, 3)
+	for i := range clients {
+		clients[i] = dialTest(t, srv, "")
+		clients[i].hello("game")
+		clients[i].sendJSON([]byte{PACKET_HUB, HUB_CMD_SC_QUICK_MATCH}, QuickMatchRequest{AppName: "game", RoomSize: 3, MinPlayers: 3})
+	}
+	rooms := make([]string, len(clients))
+	for i, c := range clients {
+		for rooms[i] == "" {
+			msg := c.read()
+			if msg[0] == 2 && msg[1] == MSG_ERROR {
+				t.Fatalf("client %d got error %d %q", i, msg[2], msg[3:])
+			} else if msg[0] == 2 && msg[1] == MSG_ROOM_JOINED {
+				rooms[i] = string(msg[3:])
+			}
+		}
+		if rooms[i] != rooms[0] {
+			t.Fatalf("client %d joined %q, want %q", i, rooms[i], rooms[0])
+		}
+	}
+	host := clients[0]
+	for i := 1; i < len(clients); i++ {
+		host.expectPlayer(PLAYER_STATE_JOINED)
+	}
+}
+
+// Quick-match joins public rooms without a secret only
+func TestQuickMatchJoinsOpenRoom(t *testing.T) {
+	_, srv := newTestHub(t)
+	locked := dialTest(t, srv, "")
+	locked.hello("game")
+	locked.createRoom(RoomRequest{AppName: "game", RoomSecret: "pwd", Public: true, AllowJoin: true, MaxPlayers: 2})
+	host := dialTest(t, srv, "")
+	host.hello("game")
+	room := host.createRoom(RoomRequest{AppName: "game", Public: true, AllowJoin: true, MaxPlayers: 2})
+
+	peer := dialTest(t, srv, "")
+	peer.hello("game")
+	peer.sendJSON([]byte{PACKET_HUB, HUB_CMD_SC_QUICK_MATCH}, QuickMatchRequest{AppName: "game", RoomSize: 2})
+	if joined := string(peer.expect(2, MSG_ROOM_JOINED)[3:]); joined != room {
+		t.Fatalf("joined %q, want %q", joined, room)
+	}
+	host.expectPlayer(PLAYER_STATE_JOINED)
+}
@@ -0,0 +1,184 @@
+package nexus
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Upper bounds in seconds of the buckets of latency histograms
+var latencyBuckets = []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1}
+
+// Histogram is a fixed bucket latency histogram safe for concurrent use
+type Histogram struct {
+	Buckets [10]int64 // One per latencyBuckets entry plus +Inf
+	Count   int64
+	SumNs   int64
+}
+
+func (h *Histogram) Observe(d time.Duration) {
+	idx := len(latencyBuckets)
+	for i, le := range latencyBuckets {
+		if d.Seconds() <= le {
+			idx = i
+			break
+		}
+	}
+	atomic.AddInt64(&h.Buckets[idx], 1)
+	atomic.AddInt64(&h.Count, 1)
+	atomic.AddInt64(&h.SumNs, int64(d))
+}
+
+// Traffic totals of all the rooms of an AppName
+type AppStats struct {
+	PacketsIn  int64
+	PacketsOut int64
+	BytesIn    int64
+	BytesOut   int64
+}
+
+// Names of the inbound message types counted by the hub, indexed by messageType
+var messageTypeNames = []string{
+	"unknown",
+	"hello",
+	"echo",
+	"hub_create_room",
+	"hub_join_room",
+	"hub_resume_session",
+	"hub_list_rooms",
+	"hub_quick_match",
+	"hub_quick_match_cancel",
+	"room_peer_packet",
+	"room_leave",
+	"room_toggle_join",
+	"room_peer_multicast",
+}
+
+// Returns the index in messageTypeNames of a client packet
+func messageType(msg []byte) int {
+	if len(msg) == 0 {
+		return 0
+	}
+	switch msg[0] {
+	case PACKET_HELLO:
+		return 1
+	case PACKET_ECHO:
+		return 2
+	case PACKET_HUB:
+		if len(msg) > 1 && msg[1] <= HUB_CMD_SC_QUICK_MATCH_CANCEL {
+			return 3 + int(msg[1])
+		}
+	case PACKET_ROOM:
+		if len(msg) > 1 && msg[1] <= ROOM_CMD_PEER_PACKET_MULTICAST {
+			return 9 + int(msg[1])
+		}
+	}
+	return 0
+}
+
+// Metrics collected by a hub besides HubStats
+type HubMetrics struct {
+	MessageCounts   [13]int64 // Indexed by messageType
+	RoomPacketQueue Histogram // Time room packets wait in Room.UserPacketChan
+	RoomCmdQueue    Histogram // Time commands wait in Room.CmdChan
+	// Sends rejected by a full or closed queue, indexed by QUEUE_ constants
+	QueueOverflows   [len(queueNames)]int64
+	QueueClosed      [len(queueNames)]int64
+	QueueDisconnects int64    // Sessions disconnected by OVERFLOW_DISCONNECT
+	AppStats         sync.Map // AppName -> *AppStats
+}
+
+// Returns the traffic totals of an AppName, creating them if needed
+func (hub *Hub) appStats(app_name string) *AppStats {
+	stats, _ := hub.Metrics.AppStats.LoadOrStore(app_name, &AppStats{})
+	return stats.(*AppStats)
+}
+
+// Escapes a prometheus label value
+func escapeLabel(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(v)
+}
+
+func writeMetric(w io.Writer, name string, kind string, help string, value int64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", name, help, name, kind, name, value)
+}
+
+func writeHistogram(w io.Writer, name string, help string, h *Histogram) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", name, help, name)
+	var cumulative int64
+	for i, le := range latencyBuckets {
+		cumulative += atomic.LoadInt64(&h.Buckets[i])
+		fmt.Fprintf(w, "%s_bucket{le=\"%g\"} %d\n", name, le, cumulative)
+	}
+	cumulative += atomic.LoadInt64(&h.Buckets[len(latencyBuckets)])
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, cumulative)
+	fmt.Fprintf(w, "%s_sum %g\n", name, time.Duration(atomic.LoadInt64(&h.SumNs)).Seconds())
+	fmt.Fprintf(w, "%s_count %d\n", name, atomic.LoadInt64(&h.Count))
+}
+
+// Prometheus metrics http request handler, writes the text exposition format
+func (hub *Hub) HandleMetricsRequest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	writeMetric(w, "gonexus_client_connections_total", "counter", "Websocket connections accepted.", atomic.LoadInt64(&hub.Stats.ClientConnections))
+	writeMetric(w, "gonexus_handshake_timeouts_total", "counter", "Connections closed for not sending the hello packet.", atomic.LoadInt64(&hub.Stats.HandshakeTimeouts))
+	writeMetric(w, "gonexus_origin_rejections_total", "counter", "Websocket upgrades and room requests refused for their origin.", atomic.LoadInt64(&hub.Stats.OriginRejections))
+	writeMetric(w, "gonexus_malformed_packets_total", "counter", "Malformed packets received, their sessions were disconnected.", atomic.LoadInt64(&hub.Stats.MalformedPackets))
+	writeMetric(w, "gonexus_oversized_frames_total", "counter", "Frames over the max message size, their connections were closed.", atomic.LoadInt64(&hub.Stats.OversizedFrames))
+	writeMetric(w, "gonexus_rate_limited_packets_total", "counter", "Packets dropped by session and room rate limits.", atomic.LoadInt64(&hub.Stats.RateLimitedPackets))
+	writeMetric(w, "gonexus_rate_limit_warnings_total", "counter", "Rate limit warnings sent to clients.", atomic.LoadInt64(&hub.Stats.RateLimitWarnings))
+	writeMetric(w, "gonexus_rate_limit_disconnects_total", "counter", "Clients disconnected for exceeding the rate limit.", atomic.LoadInt64(&hub.Stats.RateLimitDisconnects))
+	writeMetric(w, "gonexus_room_creations_total", "counter", "Rooms created.", atomic.LoadInt64(&hub.Stats.RoomCreations))
+	writeMetric(w, "gonexus_room_joins_total", "counter", "Room join requests accepted.", atomic.LoadInt64(&hub.Stats.RoomJoins))
+	writeMetric(w, "gonexus_clients", "gauge", "Connected clients.", atomic.LoadInt64(&hub.ClientCount))
+	writeMetric(w, "gonexus_rooms", "gauge", "Running rooms.", atomic.LoadInt64(&hub.RoomCount))
+
+	fmt.Fprintf(w, "# HELP gonexus_messages_total Client packets received by message type.\n# TYPE gonexus_messages_total counter\n")
+	for idx, name := range messageTypeNames {
+		fmt.Fprintf(w, "gonexus_messages_total{type=\"%s\"} %d\n", name, atomic.LoadInt64(&hub.Metrics.MessageCounts[idx]))
+	}
+
+	apps := make([]string, 0)
+	hub.Metrics.AppStats.Range(func(key any, value any) bool {
+		apps = append(apps, key.(string))
+		return true
+	})
+	slices.SortFunc(apps, cmp.Compare[string])
+	app_metrics := []struct {
+		name  string
+		help  string
+		value func(s *AppStats) int64
+	}{
+		{"gonexus_app_packets_in_total", "Room packets received per AppName.", func(s *AppStats) int64 { return atomic.LoadInt64(&s.PacketsIn) }},
+		{"gonexus_app_packets_out_total", "Room packets sent per AppName.", func(s *AppStats) int64 { return atomic.LoadInt64(&s.PacketsOut) }},
+		{"gonexus_app_bytes_in_total", "Room bytes received per AppName.", func(s *AppStats) int64 { return atomic.LoadInt64(&s.BytesIn) }},
+		{"gonexus_app_bytes_out_total", "Room bytes sent per AppName.", func(s *AppStats) int64 { return atomic.LoadInt64(&s.BytesOut) }},
+	}
+	for _, m := range app_metrics {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", m.name, m.help, m.name)
+		for _, app := range apps {
+			fmt.Fprintf(w, "%s{app=\"%s\"} %d\n", m.name, escapeLabel(app), m.value(hub.appStats(app)))
+		}
+	}
+
+	fmt.Fprintf(w, "# HELP gonexus_queue_overflows_total Sends dropped because the queue was full.\n# TYPE gonexus_queue_overflows_total counter\n")
+	for idx, name := range queueNames {
+		fmt.Fprintf(w, "gonexus_queue_overflows_total{queue=\"%s\"} %d\n", name, atomic.LoadInt64(&hub.Metrics.QueueOverflows[idx]))
+	}
+	fmt.Fprintf(w, "# HELP gonexus_queue_closed_total Sends rejected because the room or hub was closed.\n# TYPE gonexus_queue_closed_total counter\n")
+	for idx, name := range queueNames {
+		fmt.Fprintf(w, "gonexus_queue_closed_total{queue=\"%s\"} %d\n", name, atomic.LoadInt64(&hub.Metrics.QueueClosed[idx]))
+	}
+	writeMetric(w, "gonexus_queue_overflow_disconnects_total", "counter", "Sessions disconnected for overflowing a queue.", atomic.LoadInt64(&hub.Metrics.QueueDisconnects))
+	writeMetric(w, "gonexus_outbound_drops_total", "counter", "Outbound messages dropped because the peer's write buffer was full or it fell behind.", atomic.LoadInt64(&hub.Stats.OutboundDrops))
+	writeMetric(w, "gonexus_slow_peers_total", "counter", "Times a peer's outbound queue went over the slow peer limits.", atomic.LoadInt64(&hub.Stats.SlowPeers))
+	writeMetric(w, "gonexus_slow_peer_kicks_total", "counter", "Peers disconnected by the kick slow peer policy.", atomic.LoadInt64(&hub.Stats.SlowPeerKicks))
+	writeHistogram(w, "gonexus_room_packet_queue_seconds", "Time room packets wait in the room channel.", &hub.Metrics.RoomPacketQueue)
+	writeHistogram(w, "gonexus_room_cmd_queue_seconds", "Time room commands wait in the room channel.", &hub.Metrics.RoomCmdQueue)
+}
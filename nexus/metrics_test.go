@@ -0,0 +1,138 @@
+package nexus
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+var (
+	metricNameRe = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	metricLineRe = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)(\{(?:[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\[\\"n])*",?)*\})? (\S+)$`)
+)
+
+// Parses the text exposition format, failing the test on lines a prometheus scraper would
+// reject. Returns the sample values by series, name with labels.
+func parseMetrics(t *testing.T, text string) map[string]float64 {
+	t.Helper()
+	samples := make(map[string]float64)
+	types := make(map[string]string)
+	family := ""
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if help, ok := strings.CutPrefix(line, "# HELP "); ok {
+			name, _, _ := strings.Cut(help, " ")
+			if !metricNameRe.MatchString(name) {
+				t.Fatalf("invalid metric name in %q", line)
+			}
+			continue
+		}
+		if typ, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			name, kind, _ := strings.Cut(typ, " ")
+			if kind != "counter" && kind != "gauge" && kind != "histogram" {
+				t.Fatalf("invalid metric type in %q", line)
+			}
+			if _, dup := types[name]; dup {
+				t.Fatalf("metric %s declared twice", name)
+			}
+			types[name] = kind
+			family = name
+			continue
+		}
+		m := metricLineRe.FindStringSubmatch(line)
+		if m == nil {
+			t.Fatalf("invalid sample line %q", line)
+		}
+		name := m[1]
+		if types[family] == "histogram" {
+			name = strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(name, "_bucket"), "_sum"), "_count")
+		}
+		if name != family {
+			t.Fatalf("sample %q outside its metric family %s", line, family)
+		}
+		value, err := strconv.ParseFloat(m[3], 64)
+		if err != nil {
+			t.Fatalf("invalid sample value in %q", line)
+		}
+		series := m[1] + m[2]
+		if _, dup := samples[series]; dup {
+			t.Fatalf("series %s written twice", series)
+		}
+		samples[series] = value
+	}
+	return samples
+}
+
+// Checks the buckets of a histogram are cumulative and end with its count
+func checkHistogram(t *testing.T, samples map[string]float64, name string) {
+	t.Helper()
+	previous := 0.0
+	for _, le := range latencyBuckets {
+		bucket, ok := samples[name+`_bucket{le="`+strconv.FormatFloat(le, 'g', -1, 64)+`"}`]
+		if !ok || bucket < previous {
+			t.Fatalf("%s bucket %g is %g after %g", name, le, bucket, previous)
+		}
+		previous = bucket
+	}
+	inf := samples[name+`_bucket{le="+Inf"}`]
+	if inf < previous || inf != samples[name+"_count"] {
+		t.Fatalf("%s +Inf bucket %g, count %g", name, inf, samples[name+"_count"])
+	}
+}
+
+func TestMetricsEndpoint(t *testing.T) {
+	hub, srv := newTestHub(t)
+	host := dialTest(t, srv, "")
+	host.hello("game")
+	room := host.createRoom(RoomRequest{AppName: "game", RoomSecret: "pwd", AllowJoin: true})
+	peer := dialTest(t, srv, "")
+	peer.hello("game")
+	peer.joinRoom(RoomRequest{RoomId: room, AppName: "game", RoomSecret: "pwd"})
+	host.expectPlayer(PLAYER_STATE_JOINED)
+	host.send([]byte{PACKET_ROOM, ROOM_CMD_PEER_PACKET_SEND, 0, 1, 255, 'h', 'i'})
+	peer.expect(1, 0)
+	hub.appStats("we\"ird\\app\n")
+
+	resp, err := http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Fatalf("content type %q", ct)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := parseMetrics(t, string(body))
+
+	for _, series := range []string{
+		`gonexus_app_packets_in_total{app="game"}`,
+		`gonexus_app_packets_out_total{app="game"}`,
+		`gonexus_app_bytes_in_total{app="game"}`,
+		`gonexus_app_bytes_out_total{app="game"}`,
+		`gonexus_room_packet_queue_seconds_count`,
+		`gonexus_room_cmd_queue_seconds_count`,
+		`gonexus_messages_total{type="room_peer_packet"}`,
+	} {
+		if samples[series] < 1 {
+			t.Errorf("%s is %g, want at least 1", series, samples[series])
+		}
+	}
+	if _, ok := samples[`gonexus_app_packets_in_total{app="we\"ird\\app\n"}`]; !ok {
+		t.Error("app label not escaped")
+	}
+	for _, name := range queueNames {
+		if _, ok := samples[`gonexus_queue_overflows_total{queue="`+name+`"}`]; !ok {
+			t.Errorf("no overflow series for queue %s", name)
+		}
+	}
+	checkHistogram(t, samples, "gonexus_room_packet_queue_seconds")
+	checkHistogram(t, samples, "gonexus_room_cmd_queue_seconds")
+}
@@ -0,0 +1,88 @@
+package nexus
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+// Origin patterns are origins like "https://game.com", "https://*.game.com" matching any
+// subdomain of game.com, or "*" matching every origin. Requests without an Origin header,
+// sent by native clients, are not checked.
+
+var ErrOriginPattern = errors.New("origin pattern must be scheme://host[:port] or *")
+
+// Checks the syntax of an origin pattern
+func ValidateOriginPattern(pattern string) error {
+	if pattern == "*" {
+		return nil
+	}
+	scheme, host, ok := strings.Cut(pattern, "://")
+	if !ok || scheme == "" || host == "" || strings.ContainsAny(host, "/?#") || strings.Contains(host[1:], "*") {
+		return ErrOriginPattern
+	}
+	if strings.HasPrefix(host, "*") && !strings.HasPrefix(host, "*.") {
+		return ErrOriginPattern
+	}
+	return nil
+}
+
+// Tells if origin matches one of patterns
+func originAllowed(origin string, patterns []string) bool {
+	u, err := url.Parse(origin)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	host := strings.ToLower(u.Host)
+	for _, pattern := range patterns {
+		if pattern == "*" {
+			return true
+		}
+		scheme, pattern_host, _ := strings.Cut(strings.ToLower(pattern), "://")
+		if scheme != strings.ToLower(u.Scheme) {
+			continue
+		}
+		if suffix, ok := strings.CutPrefix(pattern_host, "*"); ok {
+			if len(host) > len(suffix) && strings.HasSuffix(host, suffix) {
+				return true
+			}
+		} else if host == pattern_host {
+			return true
+		}
+	}
+	return false
+}
+
+// Counts and logs a rejected origin
+func (hub *Hub) rejectOrigin(origin string, remote_addr string, app_name string) {
+	atomic.AddInt64(&hub.Stats.OriginRejections, 1)
+	log := hub.logger(LOG_SESSION).With("origin", origin, "remote_addr", remote_addr)
+	if app_name != "" {
+		log = log.With("app", app_name)
+	}
+	log.Warn("origin rejected")
+}
+
+// CheckOrigin of the websocket upgrader when the hub has an origin allowlist
+func (hub *Hub) checkRequestOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || originAllowed(origin, hub.AllowedOrigins) {
+		return true
+	}
+	hub.rejectOrigin(origin, r.RemoteAddr, "")
+	return false
+}
+
+// Checks the session's origin against the origins of an AppName, sends
+// ERR_ORIGIN_NOT_ALLOWED if it's rejected
+func (hub *Hub) checkAppOrigin(s *SessionInfo, app_name string) bool {
+	origins := hub.appPolicy(app_name).Origins
+	if len(origins) == 0 || s.Origin == "" || originAllowed(s.Origin, origins) {
+		return true
+	}
+	hub.rejectOrigin(s.Origin, s.RemoteAddr(), app_name)
+	s.SendError(ERR_ORIGIN_NOT_ALLOWED, app_name)
+	return false
+}
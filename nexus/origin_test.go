@@ -0,0 +1,102 @@
+package nexus
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+)
+
+func TestValidateOriginPattern(t *testing.T) {
+	valid := []string{"*", "https://game.com", "https://*.game.com", "http://localhost:8080", "https://game.com:8443"}
+	invalid := []string{"", "game.com", "*.game.com", "https://", "://game.com", "https://game.com/", "https://game.com/path",
+		"https://game.com?x=1", "https://game.com#x", "https://*game.com", "https://a.*.game.com", "https://game.*"}
+	for _, pattern := range valid {
+		if err := ValidateOriginPattern(pattern); err != nil {
+			t.Errorf("%q: %v", pattern, err)
+		}
+	}
+	for _, pattern := range invalid {
+		if ValidateOriginPattern(pattern) == nil {
+			t.Errorf("%q accepted", pattern)
+		}
+	}
+}
+
+func TestOriginAllowed(t *testing.T) {
+	tests := []struct {
+		origin   string
+		patterns []string
+		allowed  bool
+	}{
+		{"https://game.com", []string{"https://game.com"}, true},
+		{"https://a.game.com", []string{"https://*.game.com"}, true},
+		{"https://a.b.game.com", []string{"https://*.game.com"}, true},
+		// The wildcard only matches subdomains
+		{"https://game.com", []string{"https://*.game.com"}, false},
+		{"https://evilgame.com", []string{"https://*.game.com"}, false},
+		{"https://evilgame.com", []string{"https://game.com"}, false},
+		{"https://game.com.evil.com", []string{"https://*.game.com", "https://game.com"}, false},
+		{"https://.game.com", []string{"https://*.game.com"}, false},
+		// Ports must match the pattern
+		{"https://game.com:8443", []string{"https://game.com"}, false},
+		{"https://game.com:8443", []string{"https://game.com:8443"}, true},
+		{"https://game.com", []string{"https://game.com:8443"}, false},
+		{"https://a.game.com:8443", []string{"https://*.game.com"}, false},
+		{"https://a.game.com:8443", []string{"https://*.game.com:8443"}, true},
+		// Schemes must match
+		{"http://game.com", []string{"https://game.com"}, false},
+		{"http://a.game.com", []string{"https://*.game.com"}, false},
+		// Schemes and hosts are case insensitive
+		{"HTTPS://Game.COM", []string{"https://game.com"}, true},
+		{"https://A.Game.com", []string{"HTTPS://*.GAME.COM"}, true},
+		{"https://anything.io", []string{"*"}, true},
+		{"https://game.com", nil, false},
+		{"null", []string{"https://game.com"}, false},
+		{"game.com", []string{"https://game.com"}, false},
+	}
+	for _, tt := range tests {
+		if allowed := originAllowed(tt.origin, tt.patterns); allowed != tt.allowed {
+			t.Errorf("%q with %q: allowed %v, want %v", tt.origin, tt.patterns, allowed, tt.allowed)
+		}
+	}
+}
+
+func TestRequestOrigin(t *testing.T) {
+	hub, srv := newTestHub(t, WithAllowedOrigins("https://*.game.com"), WithAppOrigins("locked", "https://locked.game.com"))
+	for origin, status := range map[string]int{
+		"https://www.game.com": http.StatusSwitchingProtocols,
+		"":                     http.StatusSwitchingProtocols,
+		"https://game.com":     http.StatusForbidden,
+		"https://evil.com":     http.StatusForbidden,
+	} {
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		conn, res, err := testDialer.Dial(testURL(srv), header)
+		if res == nil {
+			t.Fatalf("%q: %v", origin, err)
+		}
+		if res.StatusCode != status {
+			t.Errorf("%q: status %d, want %d", origin, res.StatusCode, status)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+	}
+
+	// Apps with their own origins reject other allowed origins
+	header := http.Header{"Origin": {"https://www.game.com"}}
+	conn, _, err := testDialer.Dial(testURL(srv), header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &testClient{t: t, conn: conn}
+	defer conn.Close()
+	c.hello("locked")
+	c.sendJSON([]byte{PACKET_HUB, HUB_CMD_SC_CREATE_ROOM}, RoomRequest{AppName: "locked", RoomSecret: "pwd"})
+	c.expectError(ERR_ORIGIN_NOT_ALLOWED)
+	if n := atomic.LoadInt64(&hub.Stats.OriginRejections); n != 3 {
+		t.Errorf("%d origin rejections, want 3", n)
+	}
+}
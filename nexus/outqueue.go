@@ -0,0 +1,138 @@
+package nexus
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SlowPeerAction is the room policy applied to peers whose outbound queue falls behind
+type SlowPeerAction int
+
+const (
+	SLOW_PEER_DROP SlowPeerAction = iota // Peer packets to the slow peer are dropped
+	SLOW_PEER_WARN                       // The host gets MSG_SLOW_PEER packets
+	SLOW_PEER_KICK                       // The slow peer is disconnected
+)
+
+var slowPeerActionNames = map[string]SlowPeerAction{
+	"drop": SLOW_PEER_DROP,
+	"warn": SLOW_PEER_WARN,
+	"kick": SLOW_PEER_KICK,
+}
+
+const (
+	DefaultSlowPeerBytes = 256 * 1024
+	DefaultSlowPeerAge   = 2 * time.Second
+	// melody's default write buffer, used by sessions already unregistered from the hub
+	DefaultMessageBufferSize = 256
+)
+
+func (a SlowPeerAction) String() string {
+	for name, action := range slowPeerActionNames {
+		if action == a {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// Messages written to the melody session that the write goroutine didn't send yet. Entries
+// are pushed by SendPacket and popped by melody's sent handler, both in send order.
+type outQueue struct {
+	mut     sync.Mutex
+	entries []outEntry
+	bytes   int64
+	slow    bool // Set while the peer is behind, so the room acts once per episode
+}
+
+type outEntry struct {
+	queuedAt int64 // UnixNano
+	size     int
+}
+
+// Called by melody once msg was written to the connection
+func (s *SessionInfo) messageSent(msg []byte) {
+	s.out.mut.Lock()
+	defer s.out.mut.Unlock()
+	if len(s.out.entries) == 0 {
+		return
+	}
+	s.out.bytes -= int64(s.out.entries[0].size)
+	s.out.entries = s.out.entries[1:]
+}
+
+// Forgets the messages queued in a connection that was replaced or closed
+func (s *SessionInfo) resetOutQueue() {
+	s.out.mut.Lock()
+	defer s.out.mut.Unlock()
+	s.out.entries = nil
+	s.out.bytes = 0
+	s.out.slow = false
+}
+
+// Returns the number of queued messages, their size and the age of the oldest one
+func (s *SessionInfo) OutQueue() (packets int, bytes int64, age time.Duration) {
+	s.out.mut.Lock()
+	defer s.out.mut.Unlock()
+	if len(s.out.entries) > 0 {
+		age = time.Duration(time.Now().UnixNano() - s.out.entries[0].queuedAt)
+	}
+	return len(s.out.entries), s.out.bytes, age
+}
+
+// Tells if the outbound queue is over the hub's slow peer limits
+func (s *SessionInfo) isBehind(hub *Hub) bool {
+	packets, bytes, age := s.OutQueue()
+	if packets == 0 {
+		return false
+	}
+	return (hub.SlowPeerBytes > 0 && bytes > hub.SlowPeerBytes) ||
+		(hub.SlowPeerAge > 0 && age > hub.SlowPeerAge)
+}
+
+// Counts a message that wasn't written to the session
+func (s *SessionInfo) countOutDrop() {
+	atomic.AddInt64(&s.Stats.OutDrops, 1)
+	if hub := s.Hub; hub != nil {
+		atomic.AddInt64(&hub.Stats.OutboundDrops, 1)
+	}
+}
+
+// Sends a packet to a peer applying the room's SlowPeerAction when the peer is behind.
+// Only peer packets are dropped, server packets always go to the queue.
+func (room *Room) sendToPeer(p *SessionInfo, msg []byte) {
+	behind := p.isBehind(room.Hub)
+	p.out.mut.Lock()
+	changed := behind != p.out.slow
+	p.out.slow = behind
+	p.out.mut.Unlock()
+
+	if changed && behind {
+		room.peerLog(p).Info("slow peer", "policy", room.SlowPeer)
+		atomic.AddInt64(&room.Hub.Stats.SlowPeers, 1)
+	}
+	if changed && room.SlowPeer == SLOW_PEER_WARN && room.HostId != p.PeerId {
+		if host := room.Peers[room.HostId]; host != nil {
+			state := "0"
+			if behind {
+				state = "1"
+			}
+			host.SendPacket(buildMsgPacket(MSG_SLOW_PEER, uint8(p.PeerId), state))
+		}
+	}
+	if behind && room.SlowPeer == SLOW_PEER_KICK {
+		if changed && p.conn() != nil {
+			atomic.AddInt64(&room.Hub.Stats.SlowPeerKicks, 1)
+			p.SendError(ERR_SLOW_CONNECTION, "")
+			p.disconnect()
+		}
+		return
+	}
+	if behind && room.SlowPeer == SLOW_PEER_DROP && len(msg) > 1 && msg[0] == 1 && msg[1] == 0 {
+		p.countOutDrop()
+		return
+	}
+	p.SendPacket(msg)
+	room.countPacketOut(msg)
+}
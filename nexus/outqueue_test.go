@@ -0,0 +1,64 @@
+package nexus
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Makes the outbound queue of a session look a second old, so the next send finds it behind
+func stallOutQueue(s *SessionInfo) {
+	s.out.mut.Lock()
+	defer s.out.mut.Unlock()
+	s.out.entries = append([]outEntry{{queuedAt: time.Now().Add(-time.Second).UnixNano(), size: 1}}, s.out.entries...)
+	s.out.bytes++
+}
+
+func TestSlowPeerPolicy(t *testing.T) {
+	tests := []struct {
+		policy string
+		check  func(t *testing.T, hub *Hub, host *testClient, peer *testClient, s *SessionInfo)
+	}{
+		{"drop", func(t *testing.T, hub *Hub, host *testClient, peer *testClient, s *SessionInfo) {
+			waitFor(t, "the packet to be dropped", func() bool { return atomic.LoadInt64(&s.Stats.OutDrops) == 1 })
+			if n := atomic.LoadInt64(&hub.Stats.OutboundDrops); n != 1 {
+				t.Fatalf("%d hub outbound drops, want 1", n)
+			}
+		}},
+		{"warn", func(t *testing.T, hub *Hub, host *testClient, peer *testClient, s *SessionInfo) {
+			if msg := host.expect(2, MSG_SLOW_PEER); msg[2] != 1 || string(msg[3:]) != "1" {
+				t.Fatalf("host got %v", msg)
+			}
+			if msg := peer.expect(1, 0); string(msg[4:]) != "hi" {
+				t.Fatalf("slow peer got %v", msg)
+			}
+		}},
+		{"kick", func(t *testing.T, hub *Hub, host *testClient, peer *testClient, s *SessionInfo) {
+			peer.expectError(ERR_SLOW_CONNECTION)
+			peer.expectClose()
+			host.expectPlayer(PLAYER_STATE_LEFT)
+			if n := atomic.LoadInt64(&hub.Stats.SlowPeerKicks); n != 1 {
+				t.Fatalf("%d slow peer kicks, want 1", n)
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			hub, srv := newTestHub(t, WithSlowPeerLimits(0, 100*time.Millisecond, SLOW_PEER_DROP))
+			host := dialTest(t, srv, "")
+			host.hello("game")
+			room := host.createRoom(RoomRequest{AppName: "game", RoomSecret: "pwd", AllowJoin: true, SlowPeer: tt.policy})
+			peer := dialTest(t, srv, "")
+			s := testSession(t, hub, peer.hello("game"))
+			peer.joinRoom(RoomRequest{RoomId: room, AppName: "game", RoomSecret: "pwd"})
+			host.expectPlayer(PLAYER_STATE_JOINED)
+
+			stallOutQueue(s)
+			host.send([]byte{PACKET_ROOM, ROOM_CMD_PEER_PACKET_SEND, 0, 1, 255, 'h', 'i'})
+			tt.check(t, hub, host, peer, s)
+			if n := atomic.LoadInt64(&hub.Stats.SlowPeers); n != 1 {
+				t.Fatalf("%d slow peers, want 1", n)
+			}
+		})
+	}
+}
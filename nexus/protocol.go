@@ -0,0 +1,115 @@
+package nexus
+
+// First byte of the packets sent by clients
+const (
+	PACKET_HUB   = 0
+	PACKET_ROOM  = 1
+	PACKET_HELLO = 3
+	PACKET_ECHO  = 5
+)
+
+// Subcommands of the message packets built with buildMsgPacket
+const (
+	MSG_ROOM_JOINING    = 0
+	MSG_ERROR           = 2
+	MSG_ROOM_JOINED     = 5
+	MSG_RESUME_TOKEN    = 6
+	MSG_ROOM_LIST       = 7
+	MSG_QUICK_MATCH     = 8
+	MSG_WELCOME         = 9
+	MSG_SERVER_SHUTDOWN = 10 // The text is the number of seconds left
+	MSG_SYSTEM          = 11 // Message from the server operators
+	MSG_RATE_LIMIT      = 12 // Packets dropped by the rate limit, msgid is the warnings left
+	MSG_SLOW_PEER       = 13 // Sent to the host, msgid is the peer id, the text is "1" if behind or "0"
+	MSG_PEER_LATENCY    = 14 // Sent to the host, msgid is the peer count, the text a JSON PeerLatency list
+	MSG_INFO            = 111
+	MSG_QUEUE_WAITING   = 0 // msgid of MSG_QUICK_MATCH, the text is the queue length
+	MSG_QUEUE_CANCELED  = 1 // msgid of MSG_QUICK_MATCH
+)
+
+// ErrorCode is sent as the msgid of MSG_ERROR packets. Values are part of the protocol and
+// must never be reused, clients branch on them and localize the text themselves.
+type ErrorCode uint8
+
+const (
+	ERR_ROOM_NOT_FOUND       ErrorCode = 0
+	ERR_ROOM_LEFT            ErrorCode = 1 // Not an error, the client left the room
+	ERR_SECRET_REQUIRED      ErrorCode = 2
+	ERR_ROOM_FULL            ErrorCode = 3
+	ERR_SESSION_EXPIRED      ErrorCode = 4
+	ERR_NO_MATCH_FOUND       ErrorCode = 5
+	ERR_INVALID_PASSWORD     ErrorCode = 6
+	ERR_APP_MISMATCH         ErrorCode = 7
+	ERR_ROOM_CLOSED          ErrorCode = 8
+	ERR_JOIN_DISABLED        ErrorCode = 9
+	ERR_ROOM_EXISTS          ErrorCode = 10
+	ERR_INVALID_ROOM_SIZE    ErrorCode = 11
+	ERR_NO_ACTIVE_ROOM       ErrorCode = 12
+	ERR_ALREADY_IN_ROOM      ErrorCode = 13
+	ERR_PROTOCOL_VERSION     ErrorCode = 14
+	ERR_AUTH_REQUIRED        ErrorCode = 15
+	ERR_INVALID_TOKEN        ErrorCode = 16
+	ERR_SERVER_SHUTTING_DOWN ErrorCode = 17
+	ERR_KICKED               ErrorCode = 18
+	ERR_BANNED               ErrorCode = 19
+	ERR_RATE_LIMITED         ErrorCode = 20
+	ERR_MALFORMED_PACKET     ErrorCode = 21 // The text includes the validation error
+	ERR_SERVER_BUSY          ErrorCode = 22
+	ERR_SLOW_CONNECTION      ErrorCode = 23
+	ERR_ORIGIN_NOT_ALLOWED   ErrorCode = 24
+	ERR_INVALID_ROOM_OPTION  ErrorCode = 25 // The text includes the rejected field and value
+	ERR_MAX_ROOMS            ErrorCode = 111
+)
+
+// Default text of each error code. The text is an optional extra of MSG_ERROR packets, a hub
+// can replace it with WithErrorMessages.
+var DefaultErrorMessages = map[ErrorCode]string{
+	ERR_ROOM_NOT_FOUND:       "Juego no encontrado",
+	ERR_ROOM_LEFT:            "Juego abandonado",
+	ERR_SECRET_REQUIRED:      "Es necesaria una clave",
+	ERR_ROOM_FULL:            "Juego lleno",
+	ERR_SESSION_EXPIRED:      "Sesión expirada",
+	ERR_NO_MATCH_FOUND:       "No se encontró partida",
+	ERR_INVALID_PASSWORD:     "Contraseña inválida",
+	ERR_APP_MISMATCH:         "Version incompatible",
+	ERR_ROOM_CLOSED:          "Juego cerrado",
+	ERR_JOIN_DISABLED:        "No se aceptan nuevos jugadores",
+	ERR_ROOM_EXISTS:          "Juego ya creado",
+	ERR_INVALID_ROOM_SIZE:    "Capacidad de jugadores inválida",
+	ERR_NO_ACTIVE_ROOM:       "No hay juego activo",
+	ERR_ALREADY_IN_ROOM:      "Ya se encuentra en un juego",
+	ERR_PROTOCOL_VERSION:     "Version de protocolo no soportada",
+	ERR_AUTH_REQUIRED:        "Autenticación requerida",
+	ERR_INVALID_TOKEN:        "Token inválido",
+	ERR_SERVER_SHUTTING_DOWN: "Servidor cerrándose",
+	ERR_KICKED:               "Expulsado por un administrador",
+	ERR_BANNED:               "Acceso bloqueado",
+	ERR_RATE_LIMITED:         "Demasiados mensajes",
+	ERR_MALFORMED_PACKET:     "Paquete inválido",
+	ERR_SERVER_BUSY:          "Servidor ocupado",
+	ERR_SLOW_CONNECTION:      "Conexión demasiado lenta",
+	ERR_ORIGIN_NOT_ALLOWED:   "Origen no permitido",
+	ERR_INVALID_ROOM_OPTION:  "Opción de juego inválida",
+	ERR_MAX_ROOMS:            "Maxima capacidad de juegos simultaneos",
+}
+
+// Builds a MSG_ERROR packet, the text is the message of code followed by detail
+func buildErrorPacket(messages map[ErrorCode]string, code ErrorCode, detail string) []byte {
+	text := messages[code]
+	if detail != "" {
+		if text != "" {
+			text += ":"
+		}
+		text += detail
+	}
+	return buildMsgPacket(MSG_ERROR, uint8(code), text)
+}
+
+// Sends a MSG_ERROR packet using the messages of the session's hub
+func (s *SessionInfo) SendError(code ErrorCode, detail string) {
+	messages := DefaultErrorMessages
+	if s.Hub != nil {
+		messages = s.Hub.ErrorMessages
+	}
+	s.SendPacket(buildErrorPacket(messages, code, detail))
+}
@@ -0,0 +1,127 @@
+package nexus
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// OverflowPolicy tells what happens to a client packet when the room or hub queue it goes
+// to is full
+type OverflowPolicy int
+
+const (
+	OVERFLOW_DROP       OverflowPolicy = iota // The packet is dropped
+	OVERFLOW_DISCONNECT                       // The packet is dropped and its sender disconnected
+	OVERFLOW_BLOCK                            // The sender waits up to QueueTimeout, then drops
+)
+
+const DefaultQueueTimeout = time.Second
+
+// Queues counted in the overflow metrics
+const (
+	QUEUE_ROOM_PACKET = iota
+	QUEUE_ROOM_CMD
+	QUEUE_HUB_PACKET
+	QUEUE_HUB_CMD
+)
+
+var queueNames = [...]string{"room_packet", "room_cmd", "hub_packet", "hub_cmd"}
+
+// Outcome of a channel send
+const (
+	QUEUE_SENT = iota
+	QUEUE_CLOSED
+	QUEUE_OVERFLOW
+)
+
+// Sends v on ch unless done is closed. If ch is full it waits up to wait, a negative wait
+// blocks until ch has room or done is closed.
+func queueSend[T any](ch chan T, done chan struct{}, v T, wait time.Duration) int {
+	select {
+	case <-done:
+		return QUEUE_CLOSED
+	default:
+	}
+	select {
+	case ch <- v:
+		return QUEUE_SENT
+	default:
+	}
+	if wait == 0 {
+		return QUEUE_OVERFLOW
+	}
+	var timeout <-chan time.Time
+	if wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case ch <- v:
+		return QUEUE_SENT
+	case <-done:
+		return QUEUE_CLOSED
+	case <-timeout:
+		return QUEUE_OVERFLOW
+	}
+}
+
+// Counts the outcome of a send, returns true if it was sent
+func (hub *Hub) countQueueResult(queue int, result int) bool {
+	if result == QUEUE_CLOSED {
+		atomic.AddInt64(&hub.Metrics.QueueClosed[queue], 1)
+	} else if result == QUEUE_OVERFLOW {
+		atomic.AddInt64(&hub.Metrics.QueueOverflows[queue], 1)
+	}
+	return result == QUEUE_SENT
+}
+
+// Sends a client packet following the hub's OverflowPolicy
+func (hub *Hub) queueClientPacket(queue int, ch chan UserPacket, done chan struct{}, pkt UserPacket) bool {
+	var wait time.Duration
+	if hub.QueuePolicy == OVERFLOW_BLOCK {
+		wait = hub.QueueTimeout
+	}
+	result := queueSend(ch, done, pkt, wait)
+	if result == QUEUE_OVERFLOW && hub.QueuePolicy == OVERFLOW_DISCONNECT {
+		s := pkt.SessionI
+		s.log().Warn("queue full, disconnecting", "queue", queueNames[queue])
+		atomic.AddInt64(&hub.Metrics.QueueDisconnects, 1)
+		s.SendError(ERR_SERVER_BUSY, "")
+		s.disconnect()
+	}
+	return hub.countQueueResult(queue, result)
+}
+
+// Queues a client packet for the room goroutine, returns false if the room is closed or
+// the packet overflowed the queue
+func (room *Room) queuePacket(pkt UserPacket) bool {
+	pkt.QueuedAt = time.Now().UnixNano()
+	return room.Hub.queueClientPacket(QUEUE_ROOM_PACKET, room.UserPacketChan, room.done, pkt)
+}
+
+// Queues a command for the room goroutine, waits until there is room in the queue.
+// Returns false if the room is closed. Must not be called from the hub goroutine.
+func (room *Room) sendCmd(cmd RoomChanCmd) bool {
+	cmd.QueuedAt = time.Now().UnixNano()
+	return room.Hub.countQueueResult(QUEUE_ROOM_CMD, queueSend(room.CmdChan, room.done, cmd, -1))
+}
+
+// Like sendCmd but waits at most the hub's QueueTimeout, so the hub goroutine never
+// blocks on a busy room
+func (room *Room) offerCmd(cmd RoomChanCmd) bool {
+	cmd.QueuedAt = time.Now().UnixNano()
+	return room.Hub.countQueueResult(QUEUE_ROOM_CMD, queueSend(room.CmdChan, room.done, cmd, room.Hub.QueueTimeout))
+}
+
+// Queues a client packet for the hub goroutine, returns false if the hub is stopped or
+// the packet overflowed the queue
+func (hub *Hub) queuePacket(pkt UserPacket) bool {
+	return hub.queueClientPacket(QUEUE_HUB_PACKET, hub.UserPacketChan, hub.done, pkt)
+}
+
+// Queues a command for the hub goroutine, waits until there is room in the queue.
+// Returns false if the hub is stopped.
+func (hub *Hub) sendCmd(cmd HubChanCmd) bool {
+	return hub.countQueueResult(QUEUE_HUB_CMD, queueSend(hub.CmdChan, hub.done, cmd, -1))
+}
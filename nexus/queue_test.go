@@ -0,0 +1,162 @@
+package nexus
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Runs fn in a goroutine and returns its result, failing the test if it blocks
+func noBlock(t *testing.T, fn func() bool) bool {
+	t.Helper()
+	result := make(chan bool, 1)
+	go func() { result <- fn() }()
+	select {
+	case ok := <-result:
+		return ok
+	case <-time.After(3 * time.Second):
+		t.Fatal("send blocked")
+		return false
+	}
+}
+
+func TestQueueSend(t *testing.T) {
+	ch := make(chan int, 1)
+	done := make(chan struct{})
+	if r := queueSend(ch, done, 1, 0); r != QUEUE_SENT {
+		t.Fatalf("send to an empty queue: %d, want QUEUE_SENT", r)
+	}
+	if r := queueSend(ch, done, 2, 0); r != QUEUE_OVERFLOW {
+		t.Fatalf("send to a full queue: %d, want QUEUE_OVERFLOW", r)
+	}
+	start := time.Now()
+	if r := queueSend(ch, done, 2, 50*time.Millisecond); r != QUEUE_OVERFLOW {
+		t.Fatalf("timed send to a full queue: %d, want QUEUE_OVERFLOW", r)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("timed send returned after %v", elapsed)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		<-ch
+	}()
+	if r := queueSend(ch, done, 3, -1); r != QUEUE_SENT {
+		t.Fatalf("blocking send: %d, want QUEUE_SENT once the queue drains", r)
+	}
+
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(done)
+	}()
+	if r := queueSend(ch, done, 4, -1); r != QUEUE_CLOSED {
+		t.Fatalf("blocking send: %d, want QUEUE_CLOSED once done closes", r)
+	}
+	<-ch
+	if r := queueSend(ch, done, 5, -1); r != QUEUE_CLOSED {
+		t.Fatalf("send after close: %d, want QUEUE_CLOSED", r)
+	}
+}
+
+func TestQueueOverflowPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy OverflowPolicy
+		check  func(t *testing.T, hub *Hub, c *testClient, s *SessionInfo, elapsed time.Duration)
+	}{
+		{"drop", OVERFLOW_DROP, func(t *testing.T, hub *Hub, c *testClient, s *SessionInfo, elapsed time.Duration) {
+			if elapsed >= 100*time.Millisecond {
+				t.Fatalf("dropped after %v, want no wait", elapsed)
+			}
+			if !s.resumable() || s.conn() == nil {
+				t.Fatal("sender disconnected")
+			}
+		}},
+		{"disconnect", OVERFLOW_DISCONNECT, func(t *testing.T, hub *Hub, c *testClient, s *SessionInfo, elapsed time.Duration) {
+			c.expectError(ERR_SERVER_BUSY)
+			c.expectClose()
+			if s.resumable() {
+				t.Fatal("disconnected sender can resume")
+			}
+			if n := atomic.LoadInt64(&hub.Metrics.QueueDisconnects); n != 1 {
+				t.Fatalf("%d queue disconnects, want 1", n)
+			}
+		}},
+		{"block", OVERFLOW_BLOCK, func(t *testing.T, hub *Hub, c *testClient, s *SessionInfo, elapsed time.Duration) {
+			if elapsed < 100*time.Millisecond {
+				t.Fatalf("dropped after %v, want the queue timeout", elapsed)
+			}
+			if !s.resumable() || s.conn() == nil {
+				t.Fatal("sender disconnected")
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hub, srv := newTestHub(t, WithQueuePolicy(tt.policy, 100*time.Millisecond))
+			c := dialTest(t, srv, "")
+			s := testSession(t, hub, c.hello("game"))
+
+			ch := make(chan UserPacket, 1)
+			ch <- UserPacket{}
+			start := time.Now()
+			if hub.queueClientPacket(QUEUE_ROOM_PACKET, ch, make(chan struct{}), UserPacket{SessionI: s}) {
+				t.Fatal("packet queued on a full queue")
+			}
+			elapsed := time.Since(start)
+			if len(ch) != 1 {
+				t.Fatalf("%d packets queued, want 1", len(ch))
+			}
+			if n := atomic.LoadInt64(&hub.Metrics.QueueOverflows[QUEUE_ROOM_PACKET]); n != 1 {
+				t.Fatalf("%d overflows, want 1", n)
+			}
+			tt.check(t, hub, c, s, elapsed)
+		})
+	}
+}
+
+func TestQueueClosedRoom(t *testing.T) {
+	hub, _ := newTestHub(t, WithQueuePolicy(OVERFLOW_BLOCK, time.Minute))
+	room := &Room{Hub: hub, UserPacketChan: make(chan UserPacket), CmdChan: make(chan RoomChanCmd), done: make(chan struct{})}
+
+	// A send already waiting on the full queue returns when the room closes
+	blocked := make(chan bool, 1)
+	go func() { blocked <- room.sendCmd(RoomChanCmd{}) }()
+	time.Sleep(20 * time.Millisecond)
+	close(room.done)
+	select {
+	case ok := <-blocked:
+		if ok {
+			t.Fatal("command sent to a closed room")
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatal("sendCmd still blocked after the room closed")
+	}
+
+	if noBlock(t, func() bool { return room.sendCmd(RoomChanCmd{}) }) {
+		t.Fatal("sendCmd succeeded on a closed room")
+	}
+	if noBlock(t, func() bool { return room.offerCmd(RoomChanCmd{}) }) {
+		t.Fatal("offerCmd succeeded on a closed room")
+	}
+	if noBlock(t, func() bool { return room.queuePacket(UserPacket{}) }) {
+		t.Fatal("queuePacket succeeded on a closed room")
+	}
+	if n := atomic.LoadInt64(&hub.Metrics.QueueClosed[QUEUE_ROOM_CMD]); n != 3 {
+		t.Fatalf("%d closed room commands, want 3", n)
+	}
+	if n := atomic.LoadInt64(&hub.Metrics.QueueClosed[QUEUE_ROOM_PACKET]); n != 1 {
+		t.Fatalf("%d closed room packets, want 1", n)
+	}
+	if n := atomic.LoadInt64(&hub.Metrics.QueueOverflows[QUEUE_ROOM_CMD]); n != 0 {
+		t.Fatalf("%d room command overflows, want 0", n)
+	}
+
+	hub.Stop()
+	if noBlock(t, func() bool { return hub.sendCmd(HubChanCmd{}) }) {
+		t.Fatal("sendCmd succeeded on a stopped hub")
+	}
+	if n := atomic.LoadInt64(&hub.Metrics.QueueClosed[QUEUE_HUB_CMD]); n != 1 {
+		t.Fatalf("%d closed hub commands, want 1", n)
+	}
+}
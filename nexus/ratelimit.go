@@ -0,0 +1,133 @@
+package nexus
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RateLimits configures the token buckets applied to inbound packets. A rate of 0 disables
+// its bucket, the room buckets are shared by all the peers of a room.
+type RateLimits struct {
+	SessionPackets float64 `json:"session_packets"` // Packets per second
+	SessionBytes   float64 `json:"session_bytes"`   // Bytes per second
+	RoomPackets    float64 `json:"room_packets"`
+	RoomBytes      float64 `json:"room_bytes"`
+	Burst          float64 `json:"burst"`    // Seconds of traffic a bucket holds
+	Warnings       int     `json:"warnings"` // Warnings sent before disconnecting
+}
+
+const (
+	DefaultRateLimitBurst    = 2
+	DefaultRateLimitWarnings = 3
+	// A session over its limit is warned at most once per interval
+	RateLimitWarnInterval = time.Second
+	// Warnings are forgotten after this time without violations
+	RateLimitForgetAfter = 30 * time.Second
+)
+
+// By default rates are unlimited
+var DefaultRateLimits = RateLimits{
+	Burst:    DefaultRateLimitBurst,
+	Warnings: DefaultRateLimitWarnings,
+}
+
+type tokenBucket struct {
+	mut    sync.Mutex
+	rate   float64
+	size   float64
+	tokens float64
+	last   time.Time
+}
+
+// Returns a full bucket refilled at rate tokens per second, nil if rate is not positive
+func newTokenBucket(rate float64, burst float64) *tokenBucket {
+	if rate <= 0 {
+		return nil
+	}
+	size := rate * max(burst, 1)
+	return &tokenBucket{rate: rate, size: size, tokens: size, last: time.Now()}
+}
+
+// Adds the tokens earned since the last refill, must be called with mut held
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = min(b.size, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+}
+
+// Takes a packet of size bytes from a packet and a byte bucket if neither is empty. Both
+// are checked before taking from either, so a packet rejected by one bucket doesn't use up
+// the other. Buckets can go into debt so a single packet bigger than the bucket is still
+// allowed. Nil buckets allow everything.
+func takePacket(packets *tokenBucket, bytes *tokenBucket, size int) bool {
+	now := time.Now()
+	for _, b := range []*tokenBucket{packets, bytes} {
+		if b == nil {
+			continue
+		}
+		b.mut.Lock()
+		defer b.mut.Unlock()
+		b.refill(now)
+		if b.tokens <= 0 {
+			return false
+		}
+	}
+	if packets != nil {
+		packets.tokens--
+	}
+	if bytes != nil {
+		bytes.tokens -= float64(size)
+	}
+	return true
+}
+
+// Sets up the session buckets from the hub's limits
+func (s *SessionInfo) initRateLimit() {
+	limits := s.Hub.RateLimits
+	s.packetBucket = newTokenBucket(limits.SessionPackets, limits.Burst)
+	s.byteBucket = newTokenBucket(limits.SessionBytes, limits.Burst)
+}
+
+// Takes msg from the session buckets. Sessions over the limit are warned with
+// MSG_RATE_LIMIT and disconnected after RateLimits.Warnings warnings. Returns false if msg
+// must be dropped.
+func (s *SessionInfo) checkRateLimit(msg []byte) bool {
+	if takePacket(s.packetBucket, s.byteBucket, len(msg)) {
+		return true
+	}
+	hub := s.Hub
+	atomic.AddInt64(&s.Stats.RateLimited, 1)
+	atomic.AddInt64(&hub.Stats.RateLimitedPackets, 1)
+
+	time_now := GetUnixTimestampMS()
+	since_last := time.Duration(time_now-s.lastViolationMS) * time.Millisecond
+	if s.lastViolationMS != 0 && since_last < RateLimitWarnInterval {
+		return false
+	}
+	if since_last > RateLimitForgetAfter {
+		s.rateWarnings = 0
+	}
+	s.lastViolationMS = time_now
+	if s.rateWarnings >= hub.RateLimits.Warnings {
+		s.log().Warn("rate limit exceeded, disconnecting", "warnings", s.rateWarnings)
+		atomic.AddInt64(&hub.Stats.RateLimitDisconnects, 1)
+		s.SendError(ERR_RATE_LIMITED, "")
+		s.disconnect()
+		return false
+	}
+	s.rateWarnings++
+	atomic.AddInt64(&s.Stats.RateWarnings, 1)
+	atomic.AddInt64(&hub.Stats.RateLimitWarnings, 1)
+	s.SendPacket(buildMsgPacket(MSG_RATE_LIMIT, uint8(hub.RateLimits.Warnings-s.rateWarnings), ""))
+	return false
+}
+
+// Takes msg from the room buckets, packets over the limit are dropped
+func (room *Room) checkRateLimit(msg []byte) bool {
+	if takePacket(room.packetBucket, room.byteBucket, len(msg)) {
+		return true
+	}
+	atomic.AddInt64(&room.Stats.RateLimited, 1)
+	atomic.AddInt64(&room.Hub.Stats.RateLimitedPackets, 1)
+	return false
+}
@@ -0,0 +1,74 @@
+package nexus
+
+import (
+	"io"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+)
+
+// A packet rejected by the byte bucket must not use up a packet token
+func TestTakePacket(t *testing.T) {
+	packets := newTokenBucket(10, 1)
+	bytes := newTokenBucket(100, 1)
+	if !takePacket(packets, bytes, 200) {
+		t.Fatal("packet bigger than the byte bucket dropped")
+	}
+	for i := 0; i < 5; i++ {
+		if takePacket(packets, bytes, 1) {
+			t.Fatal("packet allowed by an empty byte bucket")
+		}
+	}
+	if packets.tokens < 9 || packets.tokens >= 9.5 {
+		t.Fatalf("%.2f packet tokens left, want 9", packets.tokens)
+	}
+	if !takePacket(nil, nil, 1000) {
+		t.Fatal("nil buckets dropped a packet")
+	}
+}
+
+// Sessions over the limit are warned once per interval and disconnected after Warnings
+// warnings
+func TestSessionRateLimit(t *testing.T) {
+	hub := NewHub(WithLogHandler(slog.NewTextHandler(io.Discard, nil)), WithRateLimits(RateLimits{SessionBytes: 10, Burst: 1, Warnings: 2}))
+	s := &SessionInfo{Hub: hub}
+	s.initRateLimit()
+	//The first packet leaves the byte bucket in debt for 9 seconds
+	msg := append([]byte{PACKET_ECHO}, make([]byte, 99)...)
+	if !s.checkRateLimit(msg) {
+		t.Fatal("packet within the limit dropped")
+	}
+	for warning := int64(1); warning <= 2; warning++ {
+		if s.checkRateLimit(msg) || s.checkRateLimit(msg) {
+			t.Fatal("packet over the limit allowed")
+		}
+		if n := atomic.LoadInt64(&s.Stats.RateWarnings); n != warning {
+			t.Fatalf("%d warnings, want %d", n, warning)
+		}
+		//The next violation is in the next warning interval
+		s.lastViolationMS -= uint64(RateLimitWarnInterval.Milliseconds())
+	}
+	if !s.resumable() || atomic.LoadInt64(&hub.Stats.RateLimitDisconnects) != 0 {
+		t.Fatal("disconnected before the last warning")
+	}
+	s.checkRateLimit(msg)
+	if s.resumable() || atomic.LoadInt64(&hub.Stats.RateLimitDisconnects) != 1 {
+		t.Fatal("not disconnected after the warnings")
+	}
+	if n := atomic.LoadInt64(&s.Stats.RateLimited); n != 5 {
+		t.Fatalf("%d packets dropped, want 5", n)
+	}
+}
+
+func TestRoomRateLimit(t *testing.T) {
+	hub := NewHub(WithLogHandler(slog.NewTextHandler(io.Discard, nil)))
+	room := &Room{Hub: hub, byteBucket: newTokenBucket(10, 1)}
+	for i, size := range []int{6, 6, 1} {
+		if allowed := room.checkRateLimit(make([]byte, size)); allowed != (i < 2) {
+			t.Fatalf("packet %d allowed %v", i, allowed)
+		}
+	}
+	if n := atomic.LoadInt64(&room.Stats.RateLimited); n != 1 {
+		t.Fatalf("%d room packets dropped, want 1", n)
+	}
+}
@@ -0,0 +1,480 @@
+package nexus
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	melody "github.com/olahol/melody"
+)
+
+const (
+	ROOM_CHAN_CMD_SEND_PACKET = iota
+	ROOM_CHAN_CMD_USER_JOIN
+	ROOM_CHAN_CMD_USER_LEAVE
+	ROOM_CHAN_CMD_ROOM_CLOSE
+	ROOM_CHAN_CMD_USER_DISCONNECT
+	ROOM_CHAN_CMD_USER_RESUME
+	ROOM_CHAN_CMD_RESUME_TIMEOUT
+	ROOM_CHAN_CMD_KICK
+	ROOM_CHAN_CMD_SET_ALLOW_JOIN
+)
+
+const (
+	ROOM_CMD_PEER_PACKET_SEND = iota
+	ROOM_CMD_LEAVE_ROOM
+	ROOM_CMD_TOOGLE_JOIN
+	ROOM_CMD_PEER_PACKET_MULTICAST
+)
+
+type RoomChanCmd struct {
+	Id           int
+	PacketTarget int
+	Msg          []byte
+	Session      *SessionInfo
+	RoomReq      *RoomRequest
+	Conn         *melody.Session
+	NewSession   *SessionInfo
+	IntVal       int
+	QueuedAt     int64 // UnixNano, set by sendCmd
+}
+
+type Room struct {
+	Mut               sync.Mutex
+	Open              atomic.Bool
+	Id                int
+	Name              string
+	Secret            string
+	AppName           string
+	Peers             []*SessionInfo
+	Hub               *Hub
+	UserPacketChan    chan (UserPacket)
+	CmdChan           chan (RoomChanCmd)
+	AllowJoin         atomic.Bool
+	HostMigration     bool
+	HostId            int
+	Public            bool
+	Title             string
+	Metadata          map[string]string
+	Region            string
+	PeerCount         int64
+	Stats             RoomStats
+	AppStats          *AppStats
+	CreationTimestamp int64
+	SlowPeer          SlowPeerAction
+	Topology          RoomTopology
+	// Closed when the room goroutine exits, sends to a closed room are rejected
+	done chan struct{}
+	// Inbound rate limit shared by the peers
+	packetBucket *tokenBucket
+	byteBucket   *tokenBucket
+	// Built once by createRoomRequest, see log()
+	logger *slog.Logger
+}
+
+type RoomStats struct {
+	PacketsIn  int64
+	PacketsOut int64
+	BytesIn    int64
+	BytesOut   int64
+	// Packets dropped by the room rate limit
+	RateLimited int64
+	// Multicast packets received, each one also counts once in PacketsIn
+	MulticastIn int64
+}
+
+type RoomRequest struct {
+	RoomId        string `json:"room_id"`
+	RoomSecret    string `json:"room_pwd"`
+	AppName       string `json:"app_name"`
+	PlayerName    string `json:"player_name"`
+	MaxPlayers    int    `json:"max_players"`
+	HostMigration bool   `json:"host_migration"`
+	ResumeToken   string `json:"resume_token"`
+	// Public rooms are listed in the room browser, for them the secret is optional
+	Public   bool              `json:"public"`
+	Title    string            `json:"title"`
+	Metadata map[string]string `json:"metadata"`
+	// Region tag used by quick-match to group players
+	Region    string `json:"region"`
+	AllowJoin bool   `json:"allow_join"`
+	// Policy for peers that fall behind: "drop", "warn" or "kick", empty uses the hub's
+	SlowPeer string `json:"slow_peer"`
+	// Routing of peer packets: "star", "mesh" or "broadcast", empty is "star"
+	Topology string `json:"topology"`
+}
+
+// Peer ids are sent as a byte and 255 is reserved as the broadcast destination, so a room
+// can't hold more than 254 peers
+const (
+	DefaultRoomPeers = 4
+	MaxRoomPeers     = 254
+)
+
+// Destination of the user packets delivered by a multicast
+const PEER_MULTICAST = 254
+
+// Bytes of a multicast destination mask covering every peer id
+const MaxMulticastMaskLen = (MaxRoomPeers + 7) / 8
+
+func (room *Room) RoomGorroutine() {
+	room.log().Debug("room goroutine started")
+	defer room.log().Debug("room goroutine exited")
+
+	atomic.AddInt64(&room.Hub.RoomCount, 1)
+	defer atomic.AddInt64(&room.Hub.RoomCount, -1)
+
+	defer close(room.done)
+	var latency_tick <-chan time.Time
+	if room.Hub.PingInterval > 0 {
+		latency_timer := time.NewTicker(room.Hub.PingInterval)
+		defer latency_timer.Stop()
+		latency_tick = latency_timer.C
+	}
+	for {
+		select {
+		case <-latency_tick:
+			room.sendLatencyReport()
+		case usrpkt := <-room.UserPacketChan:
+			room.Hub.Metrics.RoomPacketQueue.Observe(time.Duration(time.Now().UnixNano() - usrpkt.QueuedAt))
+			if room.HandlePacket(usrpkt.SessionI, usrpkt.Msg) {
+				return
+			}
+		case cmd_ch := <-room.CmdChan:
+			room.Hub.Metrics.RoomCmdQueue.Observe(time.Duration(time.Now().UnixNano() - cmd_ch.QueuedAt))
+			if cmd_ch.Id == ROOM_CHAN_CMD_SEND_PACKET {
+				room.SendPacket(255, uint8(cmd_ch.PacketTarget), cmd_ch.Msg, 255)
+			} else if cmd_ch.Id == ROOM_CHAN_CMD_ROOM_CLOSE {
+				room.closeRoom(true)
+				return
+			} else if cmd_ch.Id == ROOM_CHAN_CMD_USER_LEAVE {
+				//The peer may have left with ROOM_CMD_LEAVE_ROOM before its disconnection
+				if cmd_ch.Session.getRoom() == room && room.UserLeave(cmd_ch.Session, true) {
+					return
+				}
+			} else if cmd_ch.Id == ROOM_CHAN_CMD_USER_JOIN {
+				room.UserJoin(cmd_ch.Session, cmd_ch.RoomReq)
+			} else if cmd_ch.Id == ROOM_CHAN_CMD_USER_DISCONNECT {
+				room.UserDisconnect(cmd_ch.Session, cmd_ch.Conn)
+			} else if cmd_ch.Id == ROOM_CHAN_CMD_USER_RESUME {
+				room.UserResume(cmd_ch.Session, cmd_ch.NewSession)
+			} else if cmd_ch.Id == ROOM_CHAN_CMD_KICK {
+				if cmd_ch.Session.getRoom() == room {
+					cmd_ch.Session.SendError(ERR_KICKED, "")
+					if room.UserLeave(cmd_ch.Session, true) {
+						return
+					}
+				}
+			} else if cmd_ch.Id == ROOM_CHAN_CMD_SET_ALLOW_JOIN {
+				room.AllowJoin.Store(cmd_ch.IntVal != 0)
+			} else if cmd_ch.Id == ROOM_CHAN_CMD_RESUME_TIMEOUT {
+				s := cmd_ch.Session
+				if s.getRoom() == room && s.Reconnecting && GetUnixTimestampMS() >= s.ResumeDeadlineMS {
+					if room.UserLeave(s, true) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
+
+// Adds a sent packet to the room and AppName stats
+func (room *Room) countPacketOut(msg []byte) {
+	atomic.AddInt64(&room.Stats.PacketsOut, 1)
+	atomic.AddInt64(&room.Stats.BytesOut, int64(len(msg)))
+	atomic.AddInt64(&room.AppStats.PacketsOut, 1)
+	atomic.AddInt64(&room.AppStats.BytesOut, int64(len(msg)))
+}
+
+func buildUserPacket(ori uint8, dst uint8, msg []byte) []byte {
+	b := []byte{1, 0, ori, dst}
+	b = append(b, msg...)
+	return b
+}
+
+func (room *Room) SendPacket(ori uint8, dst uint8, msg []byte, except_peer uint8) {
+	if !room.Open.Load() {
+		return
+	}
+	if dst == 255 {
+		for idx, p := range room.Peers {
+			if p == nil || ori == uint8(idx) || except_peer == uint8(idx) {
+				continue
+			}
+			room.sendToPeer(p, msg)
+		}
+		return
+	} else if int(dst) < len(room.Peers) {
+		if room.Peers[dst] != nil {
+			room.sendToPeer(room.Peers[dst], msg)
+		} else {
+			room.log().Debug("packet to an empty peer slot", "ori", ori, "dst", dst)
+		}
+	} else {
+		room.log().Debug("packet to an invalid peer id", "ori", ori, "dst", dst)
+	}
+}
+
+// Sends msg to the peers whose bit is set in mask, bit i of mask[i/8] being peer i. The
+// message is built once and shared by every recipient, the sender is skipped.
+func (room *Room) SendMulticast(ori uint8, mask []byte, msg []byte) {
+	if !room.Open.Load() {
+		return
+	}
+	for idx, p := range room.Peers {
+		if idx/8 >= len(mask) {
+			break
+		}
+		if mask[idx/8]&(1<<(idx%8)) == 0 || p == nil || ori == uint8(idx) {
+			continue
+		}
+		room.sendToPeer(p, msg)
+	}
+}
+
+// Tells if mask only selects peer_id
+func multicastOnly(mask []byte, peer_id int) bool {
+	for idx, b := range mask {
+		if idx == peer_id/8 {
+			b &^= 1 << (peer_id % 8)
+		}
+		if b != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (room *Room) FindUserIdx(s *SessionInfo) int {
+	for idx := range room.Peers {
+		if room.Peers[idx] == s {
+			return idx
+		}
+	}
+	return -1
+}
+
+func (room *Room) UserJoin(s *SessionInfo, r *RoomRequest) {
+	peer_id := slices.Index(room.Peers, nil)
+	if peer_id >= 0 {
+		//The client may have disconnected, or joined another room, while the join was queued
+		if !s.enterRoom(room) {
+			return
+		}
+		room.Peers[peer_id] = s
+		atomic.AddInt64(&room.PeerCount, 1)
+		s.PeerId = peer_id
+		s.Name = s.displayName(r.PlayerName)
+		s.SendPacket(buildMsgPacket(MSG_ROOM_JOINING, 0, "Ingresando a Juego:"+r.RoomId))
+
+		s.SendPacket(buildPlayerPacket(uint8(s.PeerId), PLAYER_STATE_SELF, s.Name))
+		room.SendPacket(uint8(s.PeerId), 255, buildPlayerPacket(uint8(s.PeerId), PLAYER_STATE_JOINED, s.Name), uint8(peer_id))
+
+		for _, p := range room.Peers {
+			if p == nil || p == s {
+				continue
+			}
+			s.SendPacket(buildPlayerPacket(uint8(p.PeerId), PLAYER_STATE_JOINED, p.Name))
+		}
+		if room.HostId != 0 {
+			//Host was migrated, clients assume peer 0 as host otherwise
+			host := room.Peers[room.HostId]
+			s.SendPacket(buildPlayerPacket(uint8(host.PeerId), PLAYER_STATE_HOST, host.Name))
+		}
+
+		s.SendPacket(buildMsgPacket(MSG_ROOM_JOINED, 0, r.RoomId))
+		s.Hub.issueResumeToken(s)
+
+	} else {
+		s.SendError(ERR_ROOM_FULL, r.RoomId)
+	}
+}
+
+// Unregisters session from Room. If session is room's host and the room has host migration
+// enabled another peer becomes the host, otherwise disconnects all clients and returns true
+// to end Rooms gorroutine
+func (room *Room) UserLeave(s *SessionInfo, unregister_session bool) bool {
+	room.peerLog(s).Info("peer left")
+
+	if s.getRoom() == room {
+		pidx := room.FindUserIdx(s)
+		if pidx == room.HostId {
+			new_host := room.findNewHost()
+			if new_host == nil {
+				room.closeRoom(true)
+				return true
+			}
+			s.IsHost = false
+			room.removePeer(s, pidx, unregister_session)
+			room.setHost(new_host)
+		} else if pidx >= 0 {
+			room.removePeer(s, pidx, unregister_session)
+		}
+	} else {
+		s.SendError(ERR_NO_ACTIVE_ROOM, "")
+	}
+	return false
+}
+
+// Keeps the peer slot of a session whose connection dropped until it resumes or the hub's
+// resume grace period ends
+func (room *Room) UserDisconnect(s *SessionInfo, conn *melody.Session) {
+	if s.getRoom() != room || !s.swapConn(conn, nil) {
+		return
+	}
+	room.peerLog(s).Info("peer disconnected, waiting for resume")
+	s.Hub.SessionMap.Delete(conn)
+	s.Reconnecting = true
+	grace := s.Hub.ResumeGracePeriod
+	s.ResumeDeadlineMS = GetUnixTimestampMS() + uint64(grace.Milliseconds())
+	room.SendPacket(255, 255, buildPlayerPacket(uint8(s.PeerId), PLAYER_STATE_RECONNECTING, s.Name), uint8(s.PeerId))
+	time.AfterFunc(grace, func() {
+		room.sendCmd(RoomChanCmd{Id: ROOM_CHAN_CMD_RESUME_TIMEOUT, Session: s})
+	})
+}
+
+// Reattaches the connection of new_s to the disconnected session s, new_s is discarded and
+// the client gets the room state as in a join
+func (room *Room) UserResume(s *SessionInfo, new_s *SessionInfo) {
+	conn := new_s.conn()
+	if s.getRoom() != room || !s.Reconnecting || conn == nil || !new_s.swapConn(conn, nil) {
+		new_s.SendError(ERR_SESSION_EXPIRED, "")
+		return
+	}
+	room.peerLog(s).Info("peer resumed")
+	s.Hub.SessionMap.Store(conn, s)
+	s.Hub.UnregisterClient(new_s)
+
+	s.resetOutQueue()
+	s.swapConn(nil, conn)
+	s.Reconnecting = false
+	s.SendPacket(buildMsgPacket(MSG_ROOM_JOINING, 0, "Ingresando a Juego:"+room.Name))
+	s.SendPacket(buildPlayerPacket(uint8(s.PeerId), PLAYER_STATE_SELF, s.Name))
+	for _, p := range room.Peers {
+		if p == nil || p == s {
+			continue
+		}
+		s.SendPacket(buildPlayerPacket(uint8(p.PeerId), PLAYER_STATE_JOINED, p.Name))
+		if p.Reconnecting {
+			s.SendPacket(buildPlayerPacket(uint8(p.PeerId), PLAYER_STATE_RECONNECTING, p.Name))
+		}
+	}
+	if room.HostId != 0 {
+		host := room.Peers[room.HostId]
+		s.SendPacket(buildPlayerPacket(uint8(host.PeerId), PLAYER_STATE_HOST, host.Name))
+	}
+	s.SendPacket(buildMsgPacket(MSG_ROOM_JOINED, 0, room.Name))
+	room.SendPacket(uint8(s.PeerId), 255, buildPlayerPacket(uint8(s.PeerId), PLAYER_STATE_RECONNECTED, s.Name), uint8(s.PeerId))
+}
+
+// Frees the peer slot of a session and notifies the remaining peers
+func (room *Room) removePeer(s *SessionInfo, pidx int, unregister_session bool) {
+	s.leaveRoom(room)
+
+	room.Peers[pidx] = nil
+	atomic.AddInt64(&room.PeerCount, -1)
+
+	s.SendError(ERR_ROOM_LEFT, "")
+	room.SendPacket(255, 255, buildPlayerPacket(uint8(pidx), PLAYER_STATE_LEFT, s.Name), 255)
+
+	if unregister_session {
+		go func() {
+			time.Sleep(1 * time.Second)
+			s.disconnect()
+			s.Hub.UnregisterClient(s)
+		}()
+	}
+}
+
+// Returns the peer that takes the host role when the current host leaves, nil if the room
+// doesn't migrate hosts or there are no other peers
+func (room *Room) findNewHost() *SessionInfo {
+	if !room.HostMigration {
+		return nil
+	}
+	for idx, p := range room.Peers {
+		if p != nil && idx != room.HostId {
+			return p
+		}
+	}
+	return nil
+}
+
+// Gives the host role to a peer and announces it to every peer of the room
+func (room *Room) setHost(s *SessionInfo) {
+	room.peerLog(s).Info("host set")
+	s.IsHost = true
+	room.HostId = s.PeerId
+	room.SendPacket(255, 255, buildPlayerPacket(uint8(s.PeerId), PLAYER_STATE_HOST, s.Name), 255)
+}
+
+func (room *Room) closeRoom(unregister_sessions bool) {
+	room.log().Info("room closed")
+	room.Open.Store(false)
+
+	for idx, p := range room.Peers {
+		if p == nil {
+			continue
+		}
+		room.Peers[idx] = nil
+		atomic.AddInt64(&room.PeerCount, -1)
+		p.leaveRoom(room)
+		p.SendError(ERR_ROOM_CLOSED, "")
+		if unregister_sessions {
+			go func() {
+				time.Sleep(1 * time.Second)
+				p.disconnect()
+				p.Hub.UnregisterClient(p)
+			}()
+		}
+	}
+
+	room.Hub.sendCmd(HubChanCmd{Id: HUB_CHAN_CMD_ROOM_UNREGISTER, Room: room})
+}
+
+// Processes a client packet in the room goroutine, returns true if the room was closed
+func (room *Room) HandlePacket(sessionI *SessionInfo, msg []byte) bool {
+	atomic.AddInt64(&room.Stats.PacketsIn, 1)
+	atomic.AddInt64(&room.Stats.BytesIn, int64(len(msg)))
+	atomic.AddInt64(&room.AppStats.PacketsIn, 1)
+	atomic.AddInt64(&room.AppStats.BytesIn, int64(len(msg)))
+	//atomic.AddUint64(&sessionI.Stats.PacketsIn, 1)
+	//atomic.AddUint64(&sessionI.Stats.BytesIn, uint64(len(msg)))
+
+	if len(msg) > 4 && msg[0] == ROOM_CMD_PEER_PACKET_SEND {
+		msg[1] = byte(sessionI.PeerId) //Origin field is written in server, not client
+
+		if !room.canSend(sessionI, msg[2]) {
+			room.peerLog(sessionI).Debug("packet not allowed by the room topology", "topology", room.Topology, "dst", msg[2])
+			return false
+		}
+		room.SendPacket(msg[1], msg[2], buildUserPacket(msg[1], msg[2], msg[4:]), msg[3])
+		return false
+
+	} else if len(msg) > 3 && msg[0] == ROOM_CMD_PEER_PACKET_MULTICAST {
+		msg[1] = byte(sessionI.PeerId)
+		mask_len := int(msg[2])
+		mask := msg[3 : 3+mask_len]
+		atomic.AddInt64(&room.Stats.MulticastIn, 1)
+
+		if !room.canMulticast(sessionI, mask) {
+			room.peerLog(sessionI).Debug("packet not allowed by the room topology", "topology", room.Topology, "mask", mask)
+			return false
+		}
+		room.SendMulticast(msg[1], mask, buildUserPacket(msg[1], PEER_MULTICAST, msg[3+mask_len:]))
+		return false
+
+	} else if len(msg) == 1 && msg[0] == ROOM_CMD_LEAVE_ROOM {
+		room.peerLog(sessionI).Debug("leave packet")
+		return room.UserLeave(sessionI, true)
+	} else if len(msg) == 2 && msg[0] == ROOM_CMD_TOOGLE_JOIN && sessionI.IsHost {
+		sessionI.SendPacket(buildMsgPacket(MSG_INFO, 0, "allowjoin toogle"))
+		room.AllowJoin.Store(msg[1] != 0)
+		return false
+	}
+	room.peerLog(sessionI).Debug("room command not allowed", "cmd", msg[0])
+	return false
+}
@@ -0,0 +1,59 @@
+package nexus
+
+import (
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// Creates a room with host migration set as asked and joins two peers to it
+func hostMigrationRoom(t *testing.T, migrate bool) (*Hub, *httptest.Server, *testClient, []*testClient, string) {
+	t.Helper()
+	hub, srv := newTestHub(t)
+	host := dialTest(t, srv, "")
+	host.hello("game")
+	room := host.createRoom(RoomRequest{AppName: "game", RoomSecret: "pwd", AllowJoin: true, HostMigration: migrate})
+	peers := make([]*testClient, 2)
+	for i := range peers {
+		peers[i] = dialTest(t, srv, "")
+		peers[i].hello("game")
+		peers[i].joinRoom(RoomRequest{RoomId: room, AppName: "game", RoomSecret: "pwd"})
+		host.expectPlayer(PLAYER_STATE_JOINED)
+	}
+	peers[0].expectPlayer(PLAYER_STATE_JOINED)
+	return hub, srv, host, peers, room
+}
+
+func TestHostMigration(t *testing.T) {
+	_, srv, host, peers, room := hostMigrationRoom(t, true)
+	host.send([]byte{PACKET_ROOM, ROOM_CMD_LEAVE_ROOM})
+	for i, p := range peers {
+		if left := p.expectPlayer(PLAYER_STATE_LEFT); left != 0 {
+			t.Fatalf("peer %d: left peer %d, want 0", i, left)
+		}
+		if new_host := p.expectPlayer(PLAYER_STATE_HOST); new_host != 1 {
+			t.Fatalf("peer %d: new host %d, want 1", i, new_host)
+		}
+	}
+
+	//The new host can toggle joining and late joiners learn who the host is
+	peers[0].send([]byte{PACKET_ROOM, ROOM_CMD_TOOGLE_JOIN, 1})
+	peers[0].expect(2, MSG_INFO)
+	late := dialTest(t, srv, "")
+	late.hello("game")
+	late.sendJSON([]byte{PACKET_HUB, HUB_CMD_SC_JOIN_ROOM}, RoomRequest{RoomId: room, AppName: "game", RoomSecret: "pwd"})
+	if new_host := late.expectPlayer(PLAYER_STATE_HOST); new_host != 1 {
+		t.Fatalf("late joiner got host %d, want 1", new_host)
+	}
+	late.expect(2, MSG_ROOM_JOINED)
+}
+
+// Without host migration the room closes when its host leaves
+func TestHostLeaveClosesRoom(t *testing.T) {
+	hub, _, host, peers, _ := hostMigrationRoom(t, false)
+	host.send([]byte{PACKET_ROOM, ROOM_CMD_LEAVE_ROOM})
+	for _, p := range peers {
+		p.expectError(ERR_ROOM_CLOSED)
+	}
+	waitFor(t, "the room goroutine to exit", func() bool { return atomic.LoadInt64(&hub.RoomCount) == 0 })
+}
@@ -0,0 +1,359 @@
+package nexus
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	melody "github.com/olahol/melody"
+)
+
+// Option configures a Hub, it is applied inside NewHub before the hub is started
+type Option func(*Hub)
+
+// WithRoomSlots sets the maximum number of rooms the hub can hold at the same time
+func WithRoomSlots(n int) Option {
+	return func(hub *Hub) {
+		if n > 0 {
+			hub.Rooms = make([]*Room, n)
+		}
+	}
+}
+
+// WithMaxRoomPeers sets the server-wide limit for the max_players field of a room
+// creation request. Values outside 1..MaxRoomPeers are ignored.
+func WithMaxRoomPeers(n int) Option {
+	return func(hub *Hub) {
+		if n > 0 && n <= MaxRoomPeers {
+			hub.MaxRoomPeers = n
+		}
+	}
+}
+
+// WithResumeGracePeriod enables session resuming. A peer whose connection drops keeps its
+// slot for d, clients get a resume token when they join a room.
+func WithResumeGracePeriod(d time.Duration) Option {
+	return func(hub *Hub) {
+		hub.ResumeGracePeriod = d
+	}
+}
+
+// WithQuickMatchTimeout sets how long a client waits in a quick-match queue
+func WithQuickMatchTimeout(d time.Duration) Option {
+	return func(hub *Hub) {
+		if d > 0 {
+			hub.QuickMatchTimeout = d
+		}
+	}
+}
+
+// WithErrorMessages replaces the text sent along with error codes, codes missing in
+// messages are sent without text
+func WithErrorMessages(messages map[ErrorCode]string) Option {
+	return func(hub *Hub) {
+		hub.ErrorMessages = messages
+	}
+}
+
+// WithMinProtocolVersion sets the oldest protocol version accepted in the handshake
+func WithMinProtocolVersion(version int) Option {
+	return func(hub *Hub) {
+		hub.MinProtocolVersion = version
+	}
+}
+
+// WithHandshakeTimeout sets the time a client has to send the hello packet before its
+// connection is closed
+func WithHandshakeTimeout(d time.Duration) Option {
+	return func(hub *Hub) {
+		if d > 0 {
+			hub.HandshakeTimeout = d
+		}
+	}
+}
+
+// WithAuthKey sets the HMAC key used to verify HS256 auth tokens
+func WithAuthKey(key []byte) Option {
+	return func(hub *Hub) {
+		hub.AuthKey = key
+	}
+}
+
+// WithAuthPolicy sets the auth policy of the rooms of an AppName, app_name "" sets the
+// policy of apps without one
+func WithAuthPolicy(app_name string, policy AuthPolicy) Option {
+	return func(hub *Hub) {
+		app := hub.AppPolicies[app_name]
+		app.Auth = policy
+		hub.AppPolicies[app_name] = app
+	}
+}
+
+// WithAppMaxRoomPeers limits the max_players of the rooms of an AppName below the hub's
+// MaxRoomPeers, app_name "" sets the limit of apps without one
+func WithAppMaxRoomPeers(app_name string, n int) Option {
+	return func(hub *Hub) {
+		app := hub.AppPolicies[app_name]
+		app.MaxRoomPeers = n
+		hub.AppPolicies[app_name] = app
+	}
+}
+
+// WithHubQueueSize sets the buffer size of the hub's packet and command channels
+func WithHubQueueSize(n int) Option {
+	return func(hub *Hub) {
+		if n > 0 {
+			hub.HubQueueSize = n
+		}
+	}
+}
+
+// WithRoomQueueSize sets the buffer size of the packet and command channels of each room
+func WithRoomQueueSize(n int) Option {
+	return func(hub *Hub) {
+		if n > 0 {
+			hub.RoomQueueSize = n
+		}
+	}
+}
+
+// WithMaxMessageSize sets the size limit of inbound websocket frames, connections
+// sending bigger frames are closed
+func WithMaxMessageSize(n int64) Option {
+	return func(hub *Hub) {
+		if n > 0 {
+			hub.Melody.Config.MaxMessageSize = n
+		}
+	}
+}
+
+// WithRateLimits sets the inbound packet and byte rates allowed to each session and room
+func WithRateLimits(limits RateLimits) Option {
+	return func(hub *Hub) {
+		hub.RateLimits = limits
+	}
+}
+
+// WithQueuePolicy sets what happens to client packets when a room or hub queue is full,
+// timeout is the wait of OVERFLOW_BLOCK
+func WithQueuePolicy(policy OverflowPolicy, timeout time.Duration) Option {
+	return func(hub *Hub) {
+		hub.QueuePolicy = policy
+		if timeout > 0 {
+			hub.QueueTimeout = timeout
+		}
+	}
+}
+
+// WithPingInterval sets how often sessions are pinged and room hosts get the latency of
+// their peers, 0 disables pings
+func WithPingInterval(interval time.Duration) Option {
+	return func(hub *Hub) {
+		if interval >= 0 {
+			hub.PingInterval = interval
+		}
+	}
+}
+
+// WithSlowPeerLimits sets the queued bytes and oldest message age past which a peer is
+// slow, 0 disables a limit, and the action of rooms that don't choose one
+func WithSlowPeerLimits(bytes int64, age time.Duration, action SlowPeerAction) Option {
+	return func(hub *Hub) {
+		hub.SlowPeerBytes = bytes
+		hub.SlowPeerAge = age
+		hub.SlowPeerAction = action
+	}
+}
+
+// WithLogHandler sets the handler of the hub's logs, by default they go to slog's default
+// logger. Records are filtered by the hub's levels, not by the handler's.
+func WithLogHandler(handler slog.Handler) Option {
+	return func(hub *Hub) {
+		hub.LogHandler = handler
+	}
+}
+
+// WithLogLevel sets the log level of a subsystem, subsystem "" sets the level of
+// subsystems without one
+func WithLogLevel(subsystem string, level slog.Level) Option {
+	return func(hub *Hub) {
+		if subsystem == "" {
+			hub.LogLevel = level
+		} else {
+			hub.LogLevels[subsystem] = level
+		}
+	}
+}
+
+// WithAdminToken enables the admin API, requests must send token as a bearer token
+func WithAdminToken(token string) Option {
+	return func(hub *Hub) {
+		hub.AdminToken = token
+	}
+}
+
+// WithBanStore sets the ban list checked on connection, handshake and room join. By
+// default a hub has an empty in-memory ban list.
+func WithBanStore(store *BanStore) Option {
+	return func(hub *Hub) {
+		if store != nil {
+			hub.Bans = store
+		}
+	}
+}
+
+// WithAllowedOrigins only accepts websocket upgrades from origins matching patterns, see
+// ValidateOriginPattern. Requests without an Origin header are accepted.
+func WithAllowedOrigins(patterns ...string) Option {
+	return func(hub *Hub) {
+		hub.AllowedOrigins = patterns
+		hub.Melody.Upgrader.CheckOrigin = hub.checkRequestOrigin
+	}
+}
+
+// WithAppOrigins limits the origins of the clients creating or joining rooms of an AppName,
+// app_name "" sets the origins of apps without their own
+func WithAppOrigins(app_name string, patterns ...string) Option {
+	return func(hub *Hub) {
+		app := hub.AppPolicies[app_name]
+		app.Origins = patterns
+		hub.AppPolicies[app_name] = app
+	}
+}
+
+// WithCheckOrigin sets the origin check used when upgrading /ws requests. By default
+// every origin is accepted.
+func WithCheckOrigin(fn func(r *http.Request) bool) Option {
+	return func(hub *Hub) {
+		hub.Melody.Upgrader.CheckOrigin = fn
+	}
+}
+
+// Start launches the hub goroutine. Must be called once before serving requests.
+func (hub *Hub) Start() {
+	go hub.HubGorroutine()
+	if hub.PingInterval > 0 {
+		go hub.pingLoop()
+	}
+	//melody rejects upgrades until its own goroutine runs, NewHub doesn't wait for it
+	for hub.Melody.IsClosed() {
+		select {
+		case <-hub.done:
+			return
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// Stop closes all websocket connections and ends the hub goroutine. Calls after the first,
+// including the one made by Shutdown, do nothing.
+func (hub *Hub) Stop() {
+	hub.stopOnce.Do(func() {
+		hub.Melody.Close()
+		close(hub.done)
+	})
+}
+
+// Handler returns a http.Handler serving the websocket endpoint /ws, the stats page /list,
+// the public room browser /rooms, the prometheus metrics /metrics and the JSON admin API
+// under /admin
+func (hub *Hub) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /list", hub.HandleHubListRequest)
+	mux.HandleFunc("GET /ws", hub.HandleWebsocketRequest)
+	mux.HandleFunc("GET /rooms", hub.HandleRoomListRequest)
+	mux.HandleFunc("GET /metrics", hub.HandleMetricsRequest)
+	mux.HandleFunc("GET /admin/rooms", hub.adminOnly(hub.HandleAdminRooms))
+	mux.HandleFunc("GET /admin/rooms/{name}", hub.adminOnly(hub.HandleAdminRoom))
+	mux.HandleFunc("GET /admin/sessions", hub.adminOnly(hub.HandleAdminSessions))
+	mux.HandleFunc("GET /admin/stats", hub.adminOnly(hub.HandleAdminStats))
+	mux.HandleFunc("POST /admin/sessions/{id}/kick", hub.adminOnly(hub.HandleAdminKick))
+	mux.HandleFunc("POST /admin/rooms/{name}/close", hub.adminOnly(hub.HandleAdminCloseRoom))
+	mux.HandleFunc("POST /admin/rooms/{name}/allow_join", hub.adminOnly(hub.HandleAdminAllowJoin))
+	mux.HandleFunc("POST /admin/rooms/{name}/message", hub.adminOnly(hub.HandleAdminRoomMessage))
+	mux.HandleFunc("GET /admin/bans", hub.adminOnly(hub.HandleAdminBans))
+	mux.HandleFunc("POST /admin/bans", hub.adminOnly(hub.HandleAdminAddBan))
+	mux.HandleFunc("DELETE /admin/bans/{id}", hub.adminOnly(hub.HandleAdminRemoveBan))
+	return mux
+}
+
+// Upgrades a http request to a websocket connection handled by the hub
+func (hub *Hub) HandleWebsocketRequest(w http.ResponseWriter, r *http.Request) {
+	if hub.ShuttingDown() {
+		http.Error(w, "server shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	keys := map[string]any{}
+	if token := r.URL.Query().Get("token"); token != "" {
+		keys["token"] = token
+	}
+	hub.Melody.HandleRequestWithKeys(w, r, keys)
+}
+
+// Binds melody's connection events to the hub
+func (hub *Hub) setupMelody() {
+	m := hub.Melody
+	m.HandleConnect(func(s *melody.Session) {
+		new_session := &SessionInfo{
+			Hub:                   hub,
+			Session:               s,
+			Name:                  "Player",
+			ConnectionTimestampMS: GetUnixTimestampMS(),
+			Origin:                s.Request.Header.Get("Origin"),
+			//DelayMs: 75,
+		}
+		new_session.logger = new_session.newLogger()
+		new_session.initRateLimit()
+		hub.RegisterClient(new_session)
+		if hub.checkBan(new_session) {
+			s.Close()
+		}
+	})
+	m.HandleDisconnect(func(s *melody.Session) {
+		_info, _ := hub.SessionMap.Load(s)
+		if info, _ := _info.(*SessionInfo); info != nil {
+			//Sessions in a room are unregistered by the room goroutine, the check and the
+			//unregistration are atomic so a queued join can't add the session meanwhile
+			room, conn, unregistered := info.unregister(true)
+			if unregistered {
+				hub.forgetClient(info, conn)
+				return
+			}
+			//A closed room already released its peers, so failed sends are ignored
+			if room != nil && hub.ResumeGracePeriod > 0 && info.resumable() {
+				room.sendCmd(RoomChanCmd{Id: ROOM_CHAN_CMD_USER_DISCONNECT, Session: info, Conn: s})
+			} else if room != nil {
+				room.sendCmd(RoomChanCmd{Id: ROOM_CHAN_CMD_USER_LEAVE, Session: info})
+			}
+		}
+	})
+	m.HandleError(func(s *melody.Session, err error) {
+		if errors.Is(err, websocket.ErrReadLimit) {
+			atomic.AddInt64(&hub.Stats.OversizedFrames, 1)
+			hub.logger(LOG_SESSION).Warn("frame over the size limit", "remote_addr", s.RemoteAddr().String())
+		} else if errors.Is(err, melody.ErrMessageBufferFull) {
+			atomic.AddInt64(&hub.Stats.OutboundDrops, 1)
+		}
+	})
+	m.HandlePong(func(s *melody.Session) {
+		_info, _ := hub.SessionMap.Load(s)
+		if info, _ := _info.(*SessionInfo); info != nil {
+			info.pongReceived()
+		}
+	})
+	m.HandleSentMessageBinary(func(s *melody.Session, msg []byte) {
+		_info, _ := hub.SessionMap.Load(s)
+		if info, _ := _info.(*SessionInfo); info != nil {
+			info.messageSent(msg)
+		}
+	})
+	m.HandleMessageBinary(func(s *melody.Session, msg []byte) {
+		_info, _ := hub.SessionMap.Load(s)
+		if info, _ := _info.(*SessionInfo); info != nil {
+			info.RecvPacket(msg)
+		}
+	})
+}
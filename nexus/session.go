@@ -0,0 +1,271 @@
+package nexus
+
+import (
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	melody "github.com/olahol/melody"
+)
+
+// SessionInfo holds the state of a client connection: the websocket session, the room
+// it belongs to and the traffic stats.
+type SessionInfo struct {
+	PeerId                int
+	Session               *melody.Session
+	Room                  *Room
+	Hub                   *Hub
+	Name                  string
+	IsHost                bool
+	ConnectionTimestampMS uint64
+	Stats                 SessionStats
+	UniqueId              string
+	ResumeToken           string
+	Reconnecting          bool
+	ResumeDeadlineMS      uint64
+	// Handshake data, sessions can't use the hub or rooms until Handshaked is set
+	Handshaked      bool
+	ProtocolVersion int
+	AppName         string
+	ClientBuild     string
+	Features        []string
+	// Identity bound from a verified auth token
+	Verified     bool
+	UserId       string
+	VerifiedName string
+	// Origin header of the websocket request, empty for native clients
+	Origin string
+	// Inbound rate limit, only used from the session's read goroutine
+	packetBucket    *tokenBucket
+	byteBucket      *tokenBucket
+	rateWarnings    int
+	lastViolationMS uint64
+	// Built once by setupMelody, see log()
+	logger *slog.Logger
+	// Guards Session, Room, unregistered and kicked. Session and Room are shared by the hub,
+	// room and melody goroutines, they are only accessed through conn, getRoom and the
+	// methods changing them.
+	mut          sync.Mutex
+	unregistered bool
+	kicked       bool // Set by disconnect, the peer slot isn't kept for a resume
+	// Outbound messages waiting in the websocket write buffer
+	out outQueue
+	// Outstanding server ping
+	ping pingState
+}
+
+type SessionStats struct {
+	PacketsIn  int64
+	PacketsOut int64
+	BytesIn    int64
+	BytesOut   int64
+	// Packets dropped by the rate limit and warnings sent
+	RateLimited  int64
+	RateWarnings int64
+	// Packets rejected by validatePacket or the request parsers
+	Malformed int64
+	// Outbound messages dropped because the peer fell behind
+	OutDrops int64
+	// Server pings sent and answered, smoothed round trip time and jitter in microseconds
+	Pings    int64
+	Pongs    int64
+	RttUS    int64
+	JitterUS int64
+}
+
+// Returns the websocket connection, nil once the session is disconnected or unregistered
+func (s *SessionInfo) conn() *melody.Session {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.Session
+}
+
+// Replaces the connection of the session if it still is old, returns false otherwise
+func (s *SessionInfo) swapConn(old *melody.Session, conn *melody.Session) bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.Session != old {
+		return false
+	}
+	s.Session = conn
+	return true
+}
+
+// Returns the room of the session, nil if it isn't in a room
+func (s *SessionInfo) getRoom() *Room {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return s.Room
+}
+
+// Sets the room of the session, returns false if the session was unregistered or is
+// already in a room
+func (s *SessionInfo) enterRoom(room *Room) bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.unregistered || s.Room != nil {
+		return false
+	}
+	s.Room = room
+	return true
+}
+
+// Clears the room of the session if it still is room
+func (s *SessionInfo) leaveRoom(room *Room) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.Room == room {
+		s.Room = nil
+	}
+}
+
+// Marks the session as unregistered and clears its room and connection, returns the
+// connection it had. With keep_room a session in a room is left to the room goroutine and
+// only its room is returned. ok is false if the session wasn't unregistered by this call.
+func (s *SessionInfo) unregister(keep_room bool) (room *Room, conn *melody.Session, ok bool) {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	if s.unregistered {
+		return nil, nil, false
+	}
+	if keep_room && s.Room != nil {
+		return s.Room, nil, false
+	}
+	conn = s.Session
+	s.unregistered = true
+	s.Room = nil
+	s.Session = nil
+	return nil, conn, true
+}
+
+// Closes the websocket connection, if the session still has one. Connections closed by
+// the server can't be resumed, the peer leaves its room.
+func (s *SessionInfo) disconnect() {
+	s.mut.Lock()
+	s.kicked = true
+	conn := s.Session
+	s.mut.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// Tells if the session can take back its peer slot after its connection closed
+func (s *SessionInfo) resumable() bool {
+	s.mut.Lock()
+	defer s.mut.Unlock()
+	return !s.kicked
+}
+
+// Returns the remote address of the websocket connection, empty if the session is
+// disconnected
+func (s *SessionInfo) RemoteAddr() string {
+	conn := s.conn()
+	if conn == nil {
+		return ""
+	}
+	return conn.RemoteAddr().String()
+}
+
+// Queues msg in the websocket session. Messages are dropped and counted when the session's
+// write buffer is full instead of being dropped silently by melody.
+func (s *SessionInfo) SendPacket(msg []byte) {
+	conn := s.conn()
+	if conn == nil {
+		return
+	}
+	buffer_size := DefaultMessageBufferSize
+	if hub := s.Hub; hub != nil {
+		buffer_size = hub.Melody.Config.MessageBufferSize
+	}
+	s.out.mut.Lock()
+	defer s.out.mut.Unlock()
+	if len(s.out.entries) >= buffer_size {
+		s.countOutDrop()
+		return
+	}
+	if conn.WriteBinary(msg) != nil {
+		return
+	}
+	s.out.entries = append(s.out.entries, outEntry{queuedAt: time.Now().UnixNano(), size: len(msg)})
+	s.out.bytes += int64(len(msg))
+	atomic.AddInt64(&s.Stats.PacketsOut, 1)
+	atomic.AddInt64(&s.Stats.BytesOut, int64(len(msg)))
+}
+
+func (s *SessionInfo) RecvPacket(msg []byte) {
+	atomic.AddInt64(&s.Stats.PacketsIn, 1)
+	atomic.AddInt64(&s.Stats.BytesIn, int64(len(msg)))
+	if s.conn() == nil {
+		return
+	}
+	atomic.AddInt64(&s.Hub.Metrics.MessageCounts[messageType(msg)], 1)
+	if !s.checkRateLimit(msg) {
+		return
+	}
+	if err := validatePacket(msg); err != nil {
+		s.malformedPacket(err)
+		return
+	}
+
+	if msg[0] == PACKET_HELLO {
+		s.Hub.handleHello(s, msg[1:])
+		return
+	} else if !s.Handshaked && msg[0] != PACKET_ECHO {
+		s.log().Debug("packet before handshake dropped")
+		return
+	}
+
+	if room := s.getRoom(); msg[0] == PACKET_ROOM && room != nil {
+		if room.checkRateLimit(msg) {
+			room.queuePacket(UserPacket{SessionI: s, Msg: msg[1:]})
+		}
+		return
+	} else if msg[0] == PACKET_HUB {
+		s.Hub.queuePacket(UserPacket{SessionI: s, Msg: msg[1:]})
+		return
+	} else if msg[0] == PACKET_ECHO {
+		s.log().Debug("echo", "bytes", len(msg))
+		s.SendPacket(msg)
+		return
+	}
+}
+
+type UserPacket struct {
+	Msg      []byte
+	SessionI *SessionInfo
+	QueuedAt int64 // UnixNano, set when queued in a room
+}
+
+func buildMsgPacket(subcmd uint8, msgid uint8, msg string) []byte {
+	var b = []byte{0, 0, 0}
+	b[0] = 2
+	b[1] = subcmd
+	b[2] = msgid
+	if msg != "" {
+		strb := []byte(msg)
+		b = append(b, strb...)
+	}
+	return b
+}
+
+// Player states sent in player packets
+const (
+	PLAYER_STATE_LEFT = iota
+	PLAYER_STATE_JOINED
+	PLAYER_STATE_SELF
+	PLAYER_STATE_HOST
+	PLAYER_STATE_RECONNECTING
+	PLAYER_STATE_RECONNECTED
+)
+
+func buildPlayerPacket(playerId uint8, state uint8, name string) []byte {
+	var b = []byte{1, 3, 0, 0}
+	b[2] = playerId
+	b[3] = state
+	if name != "" {
+		b = append(b, []byte(name)...)
+	}
+	return b
+}
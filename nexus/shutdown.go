@@ -0,0 +1,71 @@
+package nexus
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Shutdown drains the hub before stopping it. New websocket upgrades and room creations are
+// refused, every room is told the server shuts down in drain time and gets that long to end
+// on its own. Rooms still open after that, or when ctx is done, are closed with closeRoom
+// and the hub is stopped.
+func (hub *Hub) Shutdown(ctx context.Context, drain time.Duration) error {
+	if !hub.shuttingDown.CompareAndSwap(false, true) {
+		return nil
+	}
+	hub.logger(LOG_HUB).Info("hub shutting down", "drain", drain)
+
+	notice := buildMsgPacket(MSG_SERVER_SHUTDOWN, 0, strconv.Itoa(int(drain.Seconds())))
+	hub.RoomMap.Range(func(key any, value any) bool {
+		room := value.(*Room)
+		room.sendCmd(RoomChanCmd{Id: ROOM_CHAN_CMD_SEND_PACKET, PacketTarget: 255, Msg: notice})
+		return true
+	})
+
+	drain_ctx, cancel := context.WithTimeout(ctx, drain)
+	hub.waitRooms(drain_ctx)
+	cancel()
+
+	hub.RoomMap.Range(func(key any, value any) bool {
+		room := value.(*Room)
+		room.sendCmd(RoomChanCmd{Id: ROOM_CHAN_CMD_ROOM_CLOSE})
+		return true
+	})
+	hub.waitRooms(ctx)
+	//closeRoom disconnects peers after a second so they receive the close message
+	select {
+	case <-time.After(1500 * time.Millisecond):
+	case <-ctx.Done():
+	}
+
+	hub.Stop()
+	return ctx.Err()
+}
+
+// Returns true once Shutdown was called
+func (hub *Hub) ShuttingDown() bool {
+	return hub.shuttingDown.Load()
+}
+
+// Blocks until every room goroutine ended or ctx is done
+func (hub *Hub) waitRooms(ctx context.Context) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		empty := true
+		hub.RoomMap.Range(func(key any, value any) bool {
+			empty = false
+			return false
+		})
+		if empty && atomic.LoadInt64(&hub.RoomCount) == 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package nexus
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestShutdownThenStop(t *testing.T) {
+	hub, srv := newTestHub(t)
+	host := dialTest(t, srv, "")
+	host.hello("game")
+	host.createRoom(RoomRequest{AppName: "game", RoomSecret: "pwd"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	go hub.Shutdown(ctx, 100*time.Millisecond)
+	host.expect(2, MSG_SERVER_SHUTDOWN)
+	host.expectClose()
+
+	stopped := make(chan struct{})
+	go func() {
+		hub.Shutdown(ctx, 0)
+		hub.Stop()
+		hub.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop blocked after Shutdown")
+	}
+}
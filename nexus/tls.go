@@ -0,0 +1,134 @@
+package nexus
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// How often CertReloader.Watch checks the certificate files
+const DefaultCertReloadInterval = 10 * time.Second
+
+// CertReloader serves a TLS certificate loaded from a cert and key file pair and reloads it
+// when the files change. Only new TLS handshakes use the reloaded certificate, connections
+// already open are not affected.
+type CertReloader struct {
+	CertFile string
+	KeyFile  string
+	// Logger of the reloads, usually Hub.Logger(LOG_TLS). Nil logs to slog's default logger.
+	Logger  *slog.Logger
+	mut     sync.RWMutex
+	cert    *tls.Certificate
+	certMod time.Time
+	keyMod  time.Time
+}
+
+// Loads the certificate of a cert and key file pair
+func NewCertReloader(cert_file string, key_file string) (*CertReloader, error) {
+	r := &CertReloader{CertFile: cert_file, KeyFile: key_file}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *CertReloader) reload() error {
+	cert_info, err := os.Stat(r.CertFile)
+	if err != nil {
+		return err
+	}
+	key_info, err := os.Stat(r.KeyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return fmt.Errorf("tls certificate %s: %w", r.CertFile, err)
+	}
+	r.mut.Lock()
+	defer r.mut.Unlock()
+	r.cert = &cert
+	r.certMod = cert_info.ModTime()
+	r.keyMod = key_info.ModTime()
+	return nil
+}
+
+// Tells if a file was modified since the certificate was loaded
+func (r *CertReloader) changed() bool {
+	cert_info, err := os.Stat(r.CertFile)
+	if err != nil {
+		return false
+	}
+	key_info, err := os.Stat(r.KeyFile)
+	if err != nil {
+		return false
+	}
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	return !cert_info.ModTime().Equal(r.certMod) || !key_info.ModTime().Equal(r.keyMod)
+}
+
+// GetCertificate is meant for tls.Config.GetCertificate
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mut.RLock()
+	defer r.mut.RUnlock()
+	return r.cert, nil
+}
+
+// Watch checks the files every interval until ctx is done. A certificate that fails to load,
+// for example while the files are being replaced, keeps the previous one in use.
+func (r *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	log := r.Logger
+	if log == nil {
+		log = slog.Default().With("subsystem", LOG_TLS)
+	}
+	log = log.With("cert", r.CertFile)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !r.changed() {
+				continue
+			}
+			if err := r.reload(); err != nil {
+				log.Error("certificate reload failed", "error", err)
+			} else {
+				log.Info("certificate reloaded")
+			}
+		}
+	}
+}
+
+// HSTSHandler adds a Strict-Transport-Security header with max_age to the responses of h
+func HSTSHandler(h http.Handler, max_age time.Duration) http.Handler {
+	value := fmt.Sprintf("max-age=%d", int64(max_age.Seconds()))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		h.ServeHTTP(w, r)
+	})
+}
+
+// RedirectHandler answers every request with a permanent redirect to the same URL on
+// https, tls_listen is the address of the TLS server
+func RedirectHandler(tls_listen string) http.Handler {
+	_, port, _ := net.SplitHostPort(tls_listen)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,179 @@
+package nexus
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"log/slog"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Writes a self-signed certificate for localhost and its key, returns the certificate.
+// Each serial gets a different modification time so reloads see the change.
+func writeTestCert(t *testing.T, cert_file string, key_file string, serial int64) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key_der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod := time.Now().Add(time.Duration(serial) * time.Minute)
+	for file, block := range map[string]*pem.Block{cert_file: {Type: "CERTIFICATE", Bytes: der}, key_file: {Type: "EC PRIVATE KEY", Bytes: key_der}} {
+		if err := os.WriteFile(file, pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return cert
+}
+
+// Opens a TLS connection to addr trusting only cert
+func dialTLS(addr string, cert *x509.Certificate) error {
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: pool, ServerName: "localhost"})
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	cert_file, key_file := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	first := writeTestCert(t, cert_file, key_file, 1)
+	if _, err := NewCertReloader(cert_file, filepath.Join(dir, "missing.pem")); err == nil {
+		t.Fatal("missing key loaded")
+	}
+	certs, err := NewCertReloader(cert_file, key_file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	certs.Logger = slog.New(slog.NewTextHandler(&logs, nil))
+
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{GetCertificate: certs.GetCertificate})
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: http.NotFoundHandler(), ErrorLog: log.New(io.Discard, "", 0)}
+	go srv.Serve(ln)
+	defer srv.Close()
+	if err := dialTLS(ln.Addr().String(), first); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	watch_done := make(chan struct{})
+	go func() {
+		certs.Watch(ctx, 10*time.Millisecond)
+		close(watch_done)
+	}()
+	defer func() {
+		cancel()
+		<-watch_done
+	}()
+
+	second := writeTestCert(t, cert_file, key_file, 2)
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		cert, _ := certs.GetCertificate(nil)
+		if bytes.Equal(cert.Certificate[0], second.Raw) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("certificate not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := dialTLS(ln.Addr().String(), second); err != nil {
+		t.Fatalf("new connection doesn't use the reloaded certificate: %v", err)
+	}
+
+	//A broken certificate keeps the previous one in use
+	os.WriteFile(cert_file, []byte("broken"), 0600)
+	mod := time.Now().Add(time.Hour)
+	os.Chtimes(cert_file, mod, mod)
+	time.Sleep(50 * time.Millisecond)
+	if err := dialTLS(ln.Addr().String(), second); err != nil {
+		t.Fatalf("broken certificate replaced the previous one: %v", err)
+	}
+	cancel()
+	<-watch_done
+	for _, want := range []string{"certificate reloaded", "certificate reload failed"} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("%q not logged", want)
+		}
+	}
+}
+
+func TestRedirectHandler(t *testing.T) {
+	tests := []struct {
+		tls_listen string
+		host       string
+		want       string
+	}{
+		{":443", "example.com", "https://example.com/ws?token=x"},
+		{":443", "example.com:80", "https://example.com/ws?token=x"},
+		{"", "example.com:8080", "https://example.com/ws?token=x"},
+		{":7443", "example.com:7777", "https://example.com:7443/ws?token=x"},
+		{"0.0.0.0:8443", "[::1]:80", "https://[::1]:8443/ws?token=x"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/ws?token=x", nil)
+		req.Host = tt.host
+		w := httptest.NewRecorder()
+		RedirectHandler(tt.tls_listen).ServeHTTP(w, req)
+		if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != tt.want {
+			t.Errorf("listen %q host %q: %d %q, want %q", tt.tls_listen, tt.host, w.Code, w.Header().Get("Location"), tt.want)
+		}
+	}
+}
+
+func TestHSTSHandler(t *testing.T) {
+	h := HSTSHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}), 24*time.Hour)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusTeapot {
+		t.Fatalf("status %d, the wrapped handler wasn't called", w.Code)
+	}
+	if got := w.Header().Get("Strict-Transport-Security"); got != "max-age=86400" {
+		t.Fatalf("Strict-Transport-Security %q", got)
+	}
+}
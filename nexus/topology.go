@@ -0,0 +1,47 @@
+package nexus
+
+// RoomTopology tells which peers a peer can send packets to
+type RoomTopology int
+
+const (
+	TOPOLOGY_STAR      RoomTopology = iota // The host sends to anyone, other peers only to the host
+	TOPOLOGY_MESH                          // Every peer sends to any peer
+	TOPOLOGY_BROADCAST                     // Every packet goes to all the peers
+)
+
+var roomTopologyNames = map[string]RoomTopology{
+	"star":      TOPOLOGY_STAR,
+	"mesh":      TOPOLOGY_MESH,
+	"broadcast": TOPOLOGY_BROADCAST,
+}
+
+func (t RoomTopology) String() string {
+	for name, topology := range roomTopologyNames {
+		if topology == t {
+			return name
+		}
+	}
+	return "unknown"
+}
+
+// Tells if the room's topology lets s send a peer packet to dst
+func (room *Room) canSend(s *SessionInfo, dst uint8) bool {
+	switch room.Topology {
+	case TOPOLOGY_MESH:
+		return true
+	case TOPOLOGY_BROADCAST:
+		return dst == 255
+	}
+	return s.IsHost || int(dst) == room.HostId
+}
+
+// Tells if the room's topology lets s send a multicast packet to the peers of mask
+func (room *Room) canMulticast(s *SessionInfo, mask []byte) bool {
+	switch room.Topology {
+	case TOPOLOGY_MESH:
+		return true
+	case TOPOLOGY_BROADCAST:
+		return false
+	}
+	return s.IsHost || multicastOnly(mask, room.HostId)
+}
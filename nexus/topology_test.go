@@ -0,0 +1,62 @@
+package nexus
+
+import "testing"
+
+func TestRoomTopology(t *testing.T) {
+	host := &SessionInfo{PeerId: 0, IsHost: true}
+	peer := &SessionInfo{PeerId: 1}
+	mask_host := []byte{0b001}
+	mask_peer := []byte{0b100}
+	tests := []struct {
+		topology  RoomTopology
+		s         *SessionInfo
+		dst       uint8
+		send      bool
+		mask      []byte
+		multicast bool
+	}{
+		{TOPOLOGY_STAR, host, 2, true, mask_peer, true},
+		{TOPOLOGY_STAR, peer, 0, true, mask_host, true},
+		{TOPOLOGY_STAR, peer, 2, false, mask_peer, false},
+		{TOPOLOGY_STAR, peer, 255, false, []byte{0b101}, false},
+		{TOPOLOGY_MESH, peer, 2, true, mask_peer, true},
+		{TOPOLOGY_MESH, peer, 255, true, []byte{0b101}, true},
+		{TOPOLOGY_BROADCAST, host, 1, false, mask_peer, false},
+		{TOPOLOGY_BROADCAST, host, 255, true, mask_peer, false},
+		{TOPOLOGY_BROADCAST, peer, 0, false, mask_host, false},
+		{TOPOLOGY_BROADCAST, peer, 255, true, mask_host, false},
+	}
+	for _, tt := range tests {
+		room := &Room{Topology: tt.topology, HostId: 0}
+		if send := room.canSend(tt.s, tt.dst); send != tt.send {
+			t.Errorf("%s peer %d to %d: %v, want %v", tt.topology, tt.s.PeerId, tt.dst, send, tt.send)
+		}
+		if multicast := room.canMulticast(tt.s, tt.mask); multicast != tt.multicast {
+			t.Errorf("%s peer %d to mask %b: %v, want %v", tt.topology, tt.s.PeerId, tt.mask, multicast, tt.multicast)
+		}
+	}
+}
+
+// Peers of a mesh room reach each other directly
+func TestMeshRoom(t *testing.T) {
+	_, srv := newTestHub(t)
+	clients := make([]*testClient, 3)
+	clients[0] = dialTest(t, srv, "")
+	clients[0].hello("game")
+	room := clients[0].createRoom(RoomRequest{AppName: "game", RoomSecret: "pwd", AllowJoin: true, Topology: "mesh"})
+	for i := 1; i < len(clients); i++ {
+		clients[i] = dialTest(t, srv, "")
+		clients[i].hello("game")
+		clients[i].joinRoom(RoomRequest{RoomId: room, AppName: "game", RoomSecret: "pwd"})
+	}
+	clients[1].send([]byte{PACKET_ROOM, ROOM_CMD_PEER_PACKET_SEND, 0, 2, 255, 'a'})
+	if msg := clients[2].expect(1, 0); msg[2] != 1 || string(msg[4:]) != "a" {
+		t.Fatalf("peer 2 got %v", msg)
+	}
+	clients[2].send([]byte{PACKET_ROOM, ROOM_CMD_PEER_PACKET_MULTICAST, 0, 1, 0b011, 'b'})
+	for _, c := range clients[:2] {
+		if msg := c.expect(1, 0); msg[2] != 2 || msg[3] != PEER_MULTICAST || string(msg[4:]) != "b" {
+			t.Fatalf("multicast got %v", msg)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package nexus
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// Default limit of the size of inbound websocket frames
+const DefaultMaxMessageSize = 8192
+
+var (
+	ErrEmptyPacket    = errors.New("empty packet")
+	ErrUnknownPacket  = errors.New("unknown packet type")
+	ErrUnknownCommand = errors.New("unknown command")
+	ErrPacketLength   = errors.New("invalid packet length")
+	ErrInvalidJSON    = errors.New("payload is not a JSON object")
+)
+
+// Checks the length and structure of a client packet, including its prefix
+func validatePacket(msg []byte) error {
+	if len(msg) == 0 {
+		return ErrEmptyPacket
+	}
+	switch msg[0] {
+	case PACKET_HELLO:
+		return validateJSONObject(msg[1:])
+	case PACKET_ECHO:
+		return nil
+	case PACKET_HUB:
+		return validateHubPacket(msg[1:])
+	case PACKET_ROOM:
+		return validateRoomPacket(msg[1:])
+	}
+	return fmt.Errorf("%w %d", ErrUnknownPacket, msg[0])
+}
+
+// Hub packets are a command byte followed by a JSON request
+func validateHubPacket(msg []byte) error {
+	if len(msg) == 0 {
+		return ErrPacketLength
+	}
+	switch msg[0] {
+	case HUB_CMD_SC_CREATE_ROOM, HUB_CMD_SC_JOIN_ROOM, HUB_CMD_SC_RESUME_SESSION,
+		HUB_CMD_SC_LIST_ROOMS, HUB_CMD_SC_QUICK_MATCH:
+		return validateJSONObject(msg[1:])
+	case HUB_CMD_SC_QUICK_MATCH_CANCEL:
+		if len(msg) != 1 {
+			return ErrPacketLength
+		}
+		return nil
+	}
+	return fmt.Errorf("%w %d", ErrUnknownCommand, msg[0])
+}
+
+// Room packets are a command byte followed by fixed fields, peer packets carry at least
+// one byte of payload after the origin, destination and flags fields. Multicast packets
+// carry the origin, the mask length and the destination mask instead.
+func validateRoomPacket(msg []byte) error {
+	if len(msg) == 0 {
+		return ErrPacketLength
+	}
+	switch msg[0] {
+	case ROOM_CMD_PEER_PACKET_SEND:
+		if len(msg) < 5 {
+			return ErrPacketLength
+		}
+	case ROOM_CMD_PEER_PACKET_MULTICAST:
+		if len(msg) < 3 || msg[2] == 0 || msg[2] > MaxMulticastMaskLen || len(msg) < 4+int(msg[2]) {
+			return ErrPacketLength
+		}
+	case ROOM_CMD_LEAVE_ROOM:
+		if len(msg) != 1 {
+			return ErrPacketLength
+		}
+	case ROOM_CMD_TOOGLE_JOIN:
+		if len(msg) != 2 {
+			return ErrPacketLength
+		}
+	default:
+		return fmt.Errorf("%w %d", ErrUnknownCommand, msg[0])
+	}
+	return nil
+}
+
+func validateJSONObject(b []byte) error {
+	b = bytes.TrimSpace(b)
+	if len(b) == 0 || b[0] != '{' || !json.Valid(b) {
+		return ErrInvalidJSON
+	}
+	return nil
+}
+
+// Counts a malformed packet and disconnects the session that sent it
+func (s *SessionInfo) malformedPacket(err error) {
+	atomic.AddInt64(&s.Stats.Malformed, 1)
+	if s.Hub != nil {
+		atomic.AddInt64(&s.Hub.Stats.MalformedPackets, 1)
+	}
+	s.log().Warn("malformed packet, disconnecting", "error", err)
+	s.SendError(ERR_MALFORMED_PACKET, err.Error())
+	s.disconnect()
+}
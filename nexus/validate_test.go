@@ -0,0 +1,80 @@
+package nexus
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidatePacket(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  []byte
+		err  error
+	}{
+		{"empty frame", []byte{}, ErrEmptyPacket},
+		{"unknown prefix", []byte{9, 1, 2}, ErrUnknownPacket},
+		{"echo", []byte{PACKET_ECHO}, nil},
+		{"hello", append([]byte{PACKET_HELLO}, `{"protocol_version":2}`...), nil},
+		{"hello without json", []byte{PACKET_HELLO}, ErrInvalidJSON},
+		{"hello json array", append([]byte{PACKET_HELLO}, `[1,2]`...), ErrInvalidJSON},
+		{"hello json string", append([]byte{PACKET_HELLO}, `"x"`...), ErrInvalidJSON},
+		{"hello truncated json", append([]byte{PACKET_HELLO}, `{"app_name":`...), ErrInvalidJSON},
+		{"hub without command", []byte{PACKET_HUB}, ErrPacketLength},
+		{"hub unknown command", []byte{PACKET_HUB, 99}, ErrUnknownCommand},
+		{"hub create", append([]byte{PACKET_HUB, HUB_CMD_SC_CREATE_ROOM}, ` {"app_name":"g"} `...), nil},
+		{"hub create json number", append([]byte{PACKET_HUB, HUB_CMD_SC_CREATE_ROOM}, `12`...), ErrInvalidJSON},
+		{"hub join empty", []byte{PACKET_HUB, HUB_CMD_SC_JOIN_ROOM}, ErrInvalidJSON},
+		{"hub quick match cancel", []byte{PACKET_HUB, HUB_CMD_SC_QUICK_MATCH_CANCEL}, nil},
+		{"hub quick match cancel extra", []byte{PACKET_HUB, HUB_CMD_SC_QUICK_MATCH_CANCEL, 0}, ErrPacketLength},
+		{"room without command", []byte{PACKET_ROOM}, ErrPacketLength},
+		{"room unknown command", []byte{PACKET_ROOM, 99}, ErrUnknownCommand},
+		{"peer packet", []byte{PACKET_ROOM, ROOM_CMD_PEER_PACKET_SEND, 0, 1, 255, 'x'}, nil},
+		{"peer packet without payload", []byte{PACKET_ROOM, ROOM_CMD_PEER_PACKET_SEND, 0, 1, 255}, ErrPacketLength},
+		{"peer packet header only", []byte{PACKET_ROOM, ROOM_CMD_PEER_PACKET_SEND}, ErrPacketLength},
+		{"leave", []byte{PACKET_ROOM, ROOM_CMD_LEAVE_ROOM}, nil},
+		{"leave extra", []byte{PACKET_ROOM, ROOM_CMD_LEAVE_ROOM, 0}, ErrPacketLength},
+		{"toggle join", []byte{PACKET_ROOM, ROOM_CMD_TOOGLE_JOIN, 1}, nil},
+		{"toggle join without flag", []byte{PACKET_ROOM, ROOM_CMD_TOOGLE_JOIN}, ErrPacketLength},
+		{"multicast", []byte{PACKET_ROOM, ROOM_CMD_PEER_PACKET_MULTICAST, 0, 1, 0b10, 'x'}, nil},
+		{"multicast full mask", append(append([]byte{PACKET_ROOM, ROOM_CMD_PEER_PACKET_MULTICAST, 0, 32}, make([]byte, 32)...), 'x'), nil},
+		{"multicast mask length 0", []byte{PACKET_ROOM, ROOM_CMD_PEER_PACKET_MULTICAST, 0, 0, 'x'}, ErrPacketLength},
+		{"multicast mask length 33", append(append([]byte{PACKET_ROOM, ROOM_CMD_PEER_PACKET_MULTICAST, 0, 33}, make([]byte, 33)...), 'x'), ErrPacketLength},
+		{"multicast mask longer than packet", []byte{PACKET_ROOM, ROOM_CMD_PEER_PACKET_MULTICAST, 0, 4, 0xff, 'x'}, ErrPacketLength},
+		{"multicast without payload", []byte{PACKET_ROOM, ROOM_CMD_PEER_PACKET_MULTICAST, 0, 1, 0xff}, ErrPacketLength},
+		{"multicast without mask length", []byte{PACKET_ROOM, ROOM_CMD_PEER_PACKET_MULTICAST, 0}, ErrPacketLength},
+	}
+	for _, tt := range tests {
+		if err := validatePacket(tt.msg); !errors.Is(err, tt.err) {
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+// Malformed packets disconnect their sender and leave the room running
+func TestMalformedPacketsDisconnect(t *testing.T) {
+	_, srv := newTestHub(t)
+	host := dialTest(t, srv, "")
+	host.hello("game")
+	room := host.createRoom(RoomRequest{AppName: "game", RoomSecret: "pwd", AllowJoin: true, MaxPlayers: 4})
+
+	packets := [][]byte{
+		{},
+		{7},
+		{PACKET_ROOM},
+		{PACKET_ROOM, ROOM_CMD_PEER_PACKET_SEND, 0, 0},
+		{PACKET_ROOM, ROOM_CMD_PEER_PACKET_MULTICAST, 0, 0, 'x'},
+		{PACKET_ROOM, ROOM_CMD_PEER_PACKET_MULTICAST, 0, 200, 1, 'x'},
+		{PACKET_HUB},
+		append([]byte{PACKET_HUB, HUB_CMD_SC_LIST_ROOMS}, `[]`...),
+	}
+	for _, msg := range packets {
+		peer := dialTest(t, srv, "")
+		peer.hello("game")
+		peer.joinRoom(RoomRequest{RoomId: room, AppName: "game", RoomSecret: "pwd"})
+		peer.send(msg)
+		peer.expectError(ERR_MALFORMED_PACKET)
+		peer.expectClose()
+	}
+	host.send([]byte{PACKET_ECHO, 'x'})
+	host.expect(PACKET_ECHO, 'x')
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/krshock/mob84hub/nexus"
+)
+
+// Prefix of the environment variables, each flag can be set with the variable named after
+// it, e.g. -room-slots is GONEXUS_ROOM_SLOTS
+const envPrefix = "GONEXUS_"
+
+// Binds the command line flags to the fields of cfg
+func newFlagSet(cfg *nexus.Config, config_path *string, dry_run *bool) *flag.FlagSet {
+	fs := flag.NewFlagSet("gonexus", flag.ContinueOnError)
+	fs.StringVar(config_path, "config", "", "JSON configuration file")
+	fs.BoolVar(dry_run, "dry-run", false, "print the effective configuration and exit")
+	fs.StringVar(&cfg.Listen, "listen", cfg.Listen, "listen address")
+	fs.StringVar(&cfg.TLSCert, "tls-cert", cfg.TLSCert, "TLS certificate file, enables https and wss")
+	fs.StringVar(&cfg.TLSKey, "tls-key", cfg.TLSKey, "TLS private key file")
+	fs.StringVar(&cfg.HTTPRedirectListen, "http-redirect-listen", cfg.HTTPRedirectListen, "address of a plain HTTP listener redirecting to https, empty disables it")
+	fs.DurationVar((*time.Duration)(&cfg.HSTSMaxAge), "hsts-max-age", time.Duration(cfg.HSTSMaxAge), "max-age of the Strict-Transport-Security header, 0 disables it")
+	fs.BoolVar(&cfg.AllowAnyOrigin, "allow-any-origin", cfg.AllowAnyOrigin, "accept websocket upgrades from any origin")
+	fs.Var(listFlag{&cfg.AllowedOrigins}, "allowed-origins", "origins allowed to connect separated by commas, e.g. https://*.game.com")
+	fs.DurationVar((*time.Duration)(&cfg.HandshakeTimeout), "handshake-timeout", time.Duration(cfg.HandshakeTimeout), "time given to clients to send the hello packet")
+	fs.IntVar(&cfg.RoomSlots, "room-slots", cfg.RoomSlots, "maximum number of simultaneous rooms")
+	fs.IntVar(&cfg.MaxRoomPeers, "max-room-peers", cfg.MaxRoomPeers, "maximum max_players of a room")
+	fs.IntVar(&cfg.HubQueueSize, "hub-queue-size", cfg.HubQueueSize, "buffer size of the hub channels")
+	fs.IntVar(&cfg.RoomQueueSize, "room-queue-size", cfg.RoomQueueSize, "buffer size of the room channels")
+	fs.DurationVar((*time.Duration)(&cfg.ResumeGracePeriod), "resume-grace-period", time.Duration(cfg.ResumeGracePeriod), "time a dropped peer keeps its slot, 0 disables resuming")
+	fs.DurationVar((*time.Duration)(&cfg.QuickMatchTimeout), "quick-match-timeout", time.Duration(cfg.QuickMatchTimeout), "time a client waits in a quick-match queue")
+	fs.IntVar(&cfg.MinProtocolVersion, "min-protocol-version", cfg.MinProtocolVersion, "oldest protocol version accepted")
+	fs.StringVar(&cfg.QueuePolicy, "queue-policy", cfg.QueuePolicy, "what happens to client packets when a queue is full: drop, disconnect or block")
+	fs.DurationVar((*time.Duration)(&cfg.QueueTimeout), "queue-timeout", time.Duration(cfg.QueueTimeout), "time a client packet waits for a full queue with the block policy")
+	fs.Int64Var(&cfg.SlowPeerBytes, "slow-peer-bytes", cfg.SlowPeerBytes, "queued outbound bytes past which a peer is slow, 0 disables")
+	fs.DurationVar((*time.Duration)(&cfg.SlowPeerAge), "slow-peer-age", time.Duration(cfg.SlowPeerAge), "age of the oldest queued outbound message past which a peer is slow, 0 disables")
+	fs.StringVar(&cfg.SlowPeerAction, "slow-peer-action", cfg.SlowPeerAction, "default room policy for slow peers: drop, warn or kick")
+	fs.DurationVar((*time.Duration)(&cfg.PingInterval), "ping-interval", time.Duration(cfg.PingInterval), "interval of the server pings and the latency reports sent to hosts, 0 disables")
+	fs.Int64Var(&cfg.MaxMessageSize, "max-message-size", cfg.MaxMessageSize, "maximum size in bytes of an inbound websocket frame")
+	fs.Float64Var(&cfg.RateLimits.SessionPackets, "rate-session-packets", cfg.RateLimits.SessionPackets, "packets per second allowed to a session, 0 is unlimited")
+	fs.Float64Var(&cfg.RateLimits.SessionBytes, "rate-session-bytes", cfg.RateLimits.SessionBytes, "bytes per second allowed to a session, 0 is unlimited")
+	fs.Float64Var(&cfg.RateLimits.RoomPackets, "rate-room-packets", cfg.RateLimits.RoomPackets, "packets per second allowed to all the peers of a room, 0 is unlimited")
+	fs.Float64Var(&cfg.RateLimits.RoomBytes, "rate-room-bytes", cfg.RateLimits.RoomBytes, "bytes per second allowed to all the peers of a room, 0 is unlimited")
+	fs.Float64Var(&cfg.RateLimits.Burst, "rate-burst", cfg.RateLimits.Burst, "seconds of traffic a rate limit bucket holds")
+	fs.IntVar(&cfg.RateLimits.Warnings, "rate-warnings", cfg.RateLimits.Warnings, "rate limit warnings sent before disconnecting a client")
+	fs.DurationVar((*time.Duration)(&cfg.ShutdownDrain), "shutdown-drain", time.Duration(cfg.ShutdownDrain), "time rooms get to end on their own on shutdown, 0 closes them at once")
+	fs.StringVar(&cfg.AuthKey, "auth-key", cfg.AuthKey, "HMAC key of auth tokens")
+	fs.StringVar(&cfg.BanFile, "ban-file", cfg.BanFile, "JSON file where bans are saved, empty keeps them in memory")
+	fs.StringVar(&cfg.AdminToken, "admin-token", cfg.AdminToken, "bearer token of the admin API, empty disables it")
+	fs.StringVar(&cfg.LogFormat, "log-format", cfg.LogFormat, "log output format: text or json")
+	fs.StringVar(&cfg.LogLevel, "log-level", cfg.LogLevel, "log level: debug, info, warn or error")
+	if cfg.LogLevels == nil {
+		cfg.LogLevels = make(map[string]string)
+	}
+	fs.Var(logLevelsFlag(cfg.LogLevels), "log-levels", "log levels per subsystem, e.g. room=debug,session=warn")
+	return fs
+}
+
+// Flag value of a list of strings separated by commas, setting it replaces the list
+type listFlag struct {
+	list *[]string
+}
+
+func (f listFlag) String() string {
+	if f.list == nil {
+		return ""
+	}
+	return strings.Join(*f.list, ",")
+}
+
+func (f listFlag) Set(value string) error {
+	*f.list = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*f.list = append(*f.list, item)
+		}
+	}
+	return nil
+}
+
+// Flag value of Config.LogLevels, subsystem=level pairs separated by commas
+type logLevelsFlag map[string]string
+
+func (f logLevelsFlag) String() string {
+	pairs := make([]string, 0, len(f))
+	for name, level := range f {
+		pairs = append(pairs, name+"="+level)
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (f logLevelsFlag) Set(value string) error {
+	for _, pair := range strings.Split(value, ",") {
+		name, level, ok := strings.Cut(strings.TrimSpace(pair), "=")
+		if !ok {
+			return fmt.Errorf("%q is not subsystem=level", pair)
+		}
+		f[name] = level
+	}
+	return nil
+}
+
+// Builds the configuration from, in increasing priority: defaults, the config file,
+// environment variables and command line flags
+func loadConfig(args []string) (cfg *nexus.Config, dry_run bool, err error) {
+	var config_path string
+	//First pass only looks for -config
+	fs := newFlagSet(nexus.DefaultConfig(), &config_path, &dry_run)
+	if err := fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+	if config_path == "" {
+		config_path = os.Getenv(envPrefix + "CONFIG")
+	}
+
+	cfg = nexus.DefaultConfig()
+	if config_path != "" {
+		if err := cfg.LoadFile(config_path); err != nil {
+			return nil, false, err
+		}
+	}
+	fs = newFlagSet(cfg, &config_path, &dry_run)
+	var env_err error
+	fs.VisitAll(func(f *flag.Flag) {
+		env_name := envPrefix + strings.ToUpper(strings.ReplaceAll(f.Name, "-", "_"))
+		if value, ok := os.LookupEnv(env_name); ok && f.Name != "config" {
+			if err := f.Value.Set(value); err != nil && env_err == nil {
+				env_err = fmt.Errorf("%s: %w", env_name, err)
+			}
+		}
+	})
+	if env_err != nil {
+		return nil, false, env_err
+	}
+	if err := fs.Parse(args); err != nil {
+		return nil, false, err
+	}
+	return cfg, dry_run, cfg.Validate()
+}
@@ -1,132 +1,98 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
-	"sync/atomic"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	melody "github.com/olahol/melody"
+	"github.com/krshock/mob84hub/nexus"
 )
 
-type SessionInfo struct {
-	PeerId                int
-	Session               *melody.Session
-	Room                  *Room
-	Hub                   *Hub
-	Name                  string
-	IsHost                bool
-	ConnectionTimestampMS uint64
-	Stats                 SessionStats
-	UniqueId              string
-}
-
-type SessionStats struct {
-	PacketsIn  int64
-	PacketsOut int64
-	BytesIn    int64
-	BytesOut   int64
-}
-
-func (s *SessionInfo) SendPacket(msg []byte) {
-	if s.Session != nil {
-		s.Session.WriteBinary(msg)
-		atomic.AddInt64(&s.Stats.PacketsOut, 1)
-		atomic.AddInt64(&s.Stats.BytesOut, int64(len(msg)))
+func main() {
+	cfg, dry_run, err := loadConfig(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid configuration:", err)
+		os.Exit(2)
 	}
-}
-
-func (s *SessionInfo) RecvPacket(msg []byte) {
-	atomic.AddInt64(&s.Stats.PacketsIn, 1)
-	atomic.AddInt64(&s.Stats.BytesIn, int64(len(msg)))
-
-	if msg[0] == 1 && s.Room != nil {
-		s.Room.UserPacketChan <- UserPacket{SessionI: s, Msg: msg[1:]}
-		return
-	} else if msg[0] == 0 {
-		s.Hub.UserPacketChan <- UserPacket{SessionI: s, Msg: msg[1:]}
-		return
-	} else if msg[0] == 5 {
-		fmt.Println("Echoing msg to ", s.Session.RemoteAddr())
-		s.SendPacket(msg)
+	if dry_run {
+		fmt.Println(cfg)
 		return
 	}
-}
 
-type UserPacket struct {
-	Msg      []byte
-	SessionI *SessionInfo
-}
+	log := slog.New(cfg.NewLogHandler(os.Stderr))
+	slog.SetDefault(log)
 
-func buildMsgPacket(subcmd uint8, msgid uint8, msg string) []byte {
-	var b = []byte{0, 0, 0}
-	b[0] = 2
-	b[1] = subcmd
-	b[2] = msgid
-	if msg != "" {
-		strb := []byte(msg)
-		b = append(b, strb...)
+	bans := nexus.NewBanStore(cfg.BanFile)
+	if err := bans.Load(); err != nil {
+		log.Error("can't load ban file", "path", cfg.BanFile, "error", err)
+		os.Exit(1)
 	}
-	return b
-}
+	hub := nexus.NewHub(append(cfg.Options(), nexus.WithBanStore(bans))...)
+	hub.Start()
 
-func buildPlayerPacket(playerId uint8, state uint8, name string) []byte {
-	var b = []byte{1, 3, 0, 0}
-	b[2] = playerId
-	b[3] = state
-	if name != "" {
-		b = append(b, []byte(name)...)
+	srv := &http.Server{Addr: cfg.Listen, Handler: hub.Handler()}
+	var redirect_srv *http.Server
+	watch_ctx, stop_watch := context.WithCancel(context.Background())
+	defer stop_watch()
+	if cfg.TLSCert != "" {
+		certs, err := nexus.NewCertReloader(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			log.Error("can't load tls certificate", "error", err)
+			os.Exit(1)
+		}
+		certs.Logger = hub.Logger(nexus.LOG_TLS)
+		go certs.Watch(watch_ctx, nexus.DefaultCertReloadInterval)
+		srv.TLSConfig = &tls.Config{GetCertificate: certs.GetCertificate, MinVersion: tls.VersionTLS12}
+		if cfg.HSTSMaxAge > 0 {
+			srv.Handler = nexus.HSTSHandler(srv.Handler, time.Duration(cfg.HSTSMaxAge))
+		}
+		if cfg.HTTPRedirectListen != "" {
+			redirect_srv = &http.Server{Addr: cfg.HTTPRedirectListen, Handler: nexus.RedirectHandler(cfg.Listen)}
+			log.Info("redirecting http to https", "listen", cfg.HTTPRedirectListen)
+			go serve(redirect_srv, false)
+		}
 	}
-	return b
-}
+	log.Info("GoNexus listening", "listen", cfg.Listen, "tls", cfg.TLSCert != "")
+	go serve(srv, cfg.TLSCert != "")
 
-func HandleRequestMelody(m *melody.Melody, w http.ResponseWriter, r *http.Request, keys map[string]any) error {
-	return m.HandleRequestWithKeys(w, r, nil)
-}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+	log.Info("shutdown signal received, draining rooms")
 
-func main() {
-	m := melody.New()
-	m.Upgrader.CheckOrigin = func(r *http.Request) bool { return true }
-
-	hub := NewHub()
-	go hub.HubGorroutine()
+	//A second signal ends the process without waiting
+	ctx, stop = signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	drain := time.Duration(cfg.ShutdownDrain)
+	shutdown_ctx, cancel := context.WithTimeout(ctx, drain+10*time.Second)
+	defer cancel()
+	if err := hub.Shutdown(shutdown_ctx, drain); err != nil {
+		log.Error("hub shutdown", "error", err)
+	}
+	srv.Shutdown(shutdown_ctx)
+	if redirect_srv != nil {
+		redirect_srv.Shutdown(shutdown_ctx)
+	}
+	log.Info("GoNexus stopped")
+}
 
-	http.HandleFunc("GET /list", func(w http.ResponseWriter, r *http.Request) {
-		hub.HandleHubListRequest(w, r)
-	})
-	http.HandleFunc("GET /ws", func(w http.ResponseWriter, r *http.Request) {
-		//fmt.Println("Web request from ", r.RemoteAddr)
-		HandleRequestMelody(m, w, r, nil)
-	})
-	m.HandleConnect(func(s *melody.Session) {
-		//fmt.Println("New Connection ", s.Request.RemoteAddr)
-		new_session := &SessionInfo{
-			Hub:                   hub,
-			Session:               s,
-			Name:                  "Player",
-			ConnectionTimestampMS: GetUnixTimestampMS(),
-			//DelayMs: 75,
-		}
-		hub.RegisterClient(new_session)
-	})
-	m.HandleDisconnect(func(s *melody.Session) {
-		_info, _ := hub.SessionMap.Load(s)
-		if _info.(*SessionInfo) != nil {
-			info := _info.(*SessionInfo)
-			room := info.Room
-			if room != nil {
-				room.CmdChan <- RoomChanCmd{Id: ROOM_CHAN_CMD_USER_LEAVE, Session: info}
-			} else {
-				hub.UnregisterClient(info)
-			}
-		}
-	})
-	m.HandleMessageBinary(func(s *melody.Session, msg []byte) {
-		_info, _ := hub.SessionMap.Load(s)
-		if _info.(*SessionInfo) != nil {
-			info := _info.(*SessionInfo)
-			info.RecvPacket(msg)
-		}
-	})
-	fmt.Println("GoNexus Listening in 7777...")
-	http.ListenAndServe(":7777", nil)
+// Runs srv until it's shut down, listen errors end the process
+func serve(srv *http.Server, use_tls bool) {
+	var err error
+	if use_tls {
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		err = srv.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("server error", "listen", srv.Addr, "error", err)
+		os.Exit(1)
+	}
 }